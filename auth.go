@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"r-ssh/common"
+	"r-ssh/ssh/auth"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	providerDefault        = "default"
+	providerWhitelist      = "whitelist"
+	providerAuthorizedKeys = "authorized-keys"
+	providerCertificate    = "certificate"
+	providerHTTP           = "http"
+	providerRevoked        = "revoked"
+)
+
+// buildProviders creates every provider which is configured, keyed by the name used in auth chain
+func buildProviders(cfg *Configuration, authorities []ssh.PublicKey, revocationList *auth.RevocationList) (map[string]auth.Provider, error) {
+	providers := map[string]auth.Provider{
+		providerDefault: auth.DefaultAuthProvider,
+	}
+
+	if len(cfg.PublicKeyWhitelist) != 0 {
+		providers[providerWhitelist] = auth.NewWhitelistAuthProvider(cfg.PublicKeyWhitelist)
+	}
+
+	if cfg.AuthorizedKeysFile != "" {
+		authorizedKeysProvider, err := auth.NewAuthorizedKeysProvider(cfg.AuthorizedKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("load authorized keys: %w", err)
+		}
+		authorizedKeysProvider.Watch(common.DefaultWatchInterval)
+		providers[providerAuthorizedKeys] = authorizedKeysProvider
+	}
+
+	if len(authorities) != 0 {
+		providers[providerCertificate] = auth.NewCertificateProvider(authorities, nil)
+	}
+
+	if cfg.AuthURL != "" {
+		providers[providerHTTP] = auth.NewHTTPProvider(cfg.AuthURL, cfg.AuthTimeout, cfg.AuthCacheTTL)
+	}
+
+	if revocationList != nil {
+		providers[providerRevoked] = auth.Revoked(revocationList)
+	}
+	return providers, nil
+}
+
+// legacyProvider picks single provider the way it was done before auth chains were introduced
+func legacyProvider(providers map[string]auth.Provider, authorities []ssh.PublicKey) auth.Provider {
+	provider := providers[providerDefault]
+	for _, name := range []string{providerHTTP, providerAuthorizedKeys, providerWhitelist} {
+		if p, ok := providers[name]; ok {
+			provider = p
+			break
+		}
+	}
+
+	if len(authorities) != 0 {
+		provider = auth.NewCertificateProvider(authorities, provider)
+	}
+	return provider
+}
+
+func loadPolicies(cfg *Configuration) (map[string]*auth.Policy, error) {
+	if cfg.PolicyFile == "" {
+		return make(map[string]*auth.Policy), nil
+	}
+	policies, err := auth.LoadPolicies(cfg.PolicyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load policies: %w", err)
+	}
+	return policies, nil
+}
+
+func hasHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// buildHostPolicies resolves policies of base domains by name
+func buildHostPolicies(cfg *Configuration, policies map[string]*auth.Policy) (map[string]*auth.Policy, error) {
+	hostPolicies := make(map[string]*auth.Policy, len(cfg.HostPolicies))
+	for host, name := range cfg.HostPolicies {
+		if !hasHost(cfg.Host, host) {
+			return nil, fmt.Errorf("policy %q of unknown host %q", name, host)
+		}
+		policy, ok := policies[name]
+		if !ok {
+			return nil, fmt.Errorf("policy %q of host %q not found", name, host)
+		}
+		hostPolicies[host] = policy
+	}
+	return hostPolicies, nil
+}
+
+func buildAuthProvider(cfg *Configuration, policies map[string]*auth.Policy, revocationList *auth.RevocationList) (auth.Provider, error) {
+	var authorities []ssh.PublicKey
+	if cfg.UserCaKeys != "" {
+		var err error
+		authorities, err = auth.LoadCertificateAuthorities(cfg.UserCaKeys)
+		if err != nil {
+			return nil, fmt.Errorf("load user ca keys: %w", err)
+		}
+	}
+
+	providers, err := buildProviders(cfg, authorities, revocationList)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := legacyProvider(providers, authorities)
+	if cfg.AuthChain != "" {
+		provider, err = auth.ParseChain(cfg.AuthChain, providers, policies)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	policy, err := globalPolicy(cfg, policies)
+	if err != nil {
+		return nil, err
+	}
+	if policy != nil {
+		provider = auth.WithPolicy(provider, policy)
+	}
+	return provider, nil
+}
+
+// globalPolicy returns policy applied to every session, nil if it's not configured
+func globalPolicy(cfg *Configuration, policies map[string]*auth.Policy) (*auth.Policy, error) {
+	if cfg.Policy == "" {
+		return nil, nil
+	}
+	policy, ok := policies[cfg.Policy]
+	if !ok {
+		return nil, fmt.Errorf("policy %q not found", cfg.Policy)
+	}
+	return policy, nil
+}
+
+// buildPasswordProvider creates htpasswd provider, password sessions are restricted by the global policy as key sessions
+func buildPasswordProvider(cfg *Configuration, policies map[string]*auth.Policy) (auth.PasswordProvider, error) {
+	htpasswdProvider, err := auth.NewHtpasswdProvider(cfg.HtpasswdFile)
+	if err != nil {
+		return nil, fmt.Errorf("load htpasswd: %w", err)
+	}
+	htpasswdProvider.Watch(common.DefaultWatchInterval)
+
+	policy, err := globalPolicy(cfg, policies)
+	if err != nil {
+		return nil, err
+	}
+	return auth.WithPasswordPolicy(htpasswdProvider, policy), nil
+}
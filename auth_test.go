@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"r-ssh/common"
+	"r-ssh/ssh/auth"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBuildPasswordProvider_globalPolicy(t *testing.T) {
+	dir := t.TempDir()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Configuration{HtpasswdFile: filepath.Join(dir, "users"), Policy: "contractors"}
+	if err = ioutil.WriteFile(cfg.HtpasswdFile, []byte("alice:"+string(hash)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policies := map[string]*auth.Policy{"contractors": {AllowedTargets: []string{"localhost:8080"}}}
+
+	provider, err := buildPasswordProvider(cfg, policies)
+	if err != nil {
+		t.Fatalf("buildPasswordProvider() error: %s", err)
+	}
+	grant, err := provider.AuthPassword(&auth.Request{User: "alice"}, []byte("secret"))
+	if err != nil {
+		t.Fatalf("AuthPassword() error: %s", err)
+	}
+
+	if err = grant.Policy.CheckForward(common.BuildForwardInfo(grant.Identity, "localhost", 8080), 0); err != nil {
+		t.Errorf("CheckForward() of allowed target error: %s", err)
+	}
+	if err = grant.Policy.CheckForward(common.BuildForwardInfo(grant.Identity, "0.0.0.0", 22), 0); err != common.ErrTargetNotAllowed {
+		t.Errorf("CheckForward() of other target = %v, want %v", err, common.ErrTargetNotAllowed)
+	}
+
+	cfg.Policy = "missing"
+	if _, err = buildPasswordProvider(cfg, policies); err == nil {
+		t.Error("buildPasswordProvider() must fail with unknown policy")
+	}
+}
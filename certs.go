@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"os/signal"
+	"r-ssh/certs"
+	"r-ssh/common"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+const dnsProviderRFC2136 = "rfc2136"
+
+// buildDNSProvider creates provider updating zone of host, unless the zone is configured
+func buildDNSProvider(cfg *Configuration, host string) (certs.DNSProvider, error) {
+	switch cfg.AcmeDNSProvider {
+	case dnsProviderRFC2136:
+		zone, ok := cfg.Rfc2136Zones[host]
+		if !ok {
+			zone = cfg.Rfc2136Zone
+		}
+		if zone == "" {
+			zone = host
+		}
+		return certs.NewRFC2136Provider(cfg.Rfc2136Nameserver, zone, cfg.Rfc2136TTL, cfg.Rfc2136TsigKey, cfg.Rfc2136TsigAlgorithm, cfg.Rfc2136TsigSecret)
+	default:
+		return nil, fmt.Errorf("%w: %q", common.ErrUnknownDNSProvider, cfg.AcmeDNSProvider)
+	}
+}
+
+// buildWildcardManagers creates manager of wildcard certificate for every host, they share the account and the store.
+// Every manager gets its own client, registration sets key of the client
+func buildWildcardManagers(cfg *Configuration) (map[string]*certs.WildcardManager, error) {
+	store, err := certs.NewStore(cfg.AcmeCacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	managers := make(map[string]*certs.WildcardManager, len(cfg.Host))
+	for _, host := range cfg.Host {
+		client, err := certs.NewClient(cfg.AcmeDirectoryURL, cfg.AcmeCaFile)
+		if err != nil {
+			return nil, err
+		}
+		provider, err := buildDNSProvider(cfg, host)
+		if err != nil {
+			return nil, err
+		}
+		managers[host] = certs.NewWildcardManager(client, cfg.AcmeEmail, host, provider, store, cfg.AcmeRenewBefore, cfg.AcmePropagationDelay)
+	}
+	return managers, nil
+}
+
+// wildcardSource names the wildcard certificate of host among configured certificates
+func wildcardSource(host string) string {
+	return "acme:" + host
+}
+
+func setWildcardCertificate(certificates *certs.Certificates, host string, cert *tls.Certificate) {
+	if err := certificates.Set(wildcardSource(host), cert); err != nil {
+		logrus.WithError(err).WithField("host", host).Errorln("set wildcard certificate failed")
+	}
+}
+
+// reloadOnHangup reloads certificate files on SIGHUP, e.g. from renewal hook
+func reloadOnHangup(files *certs.FileCertificates) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		for range hangup {
+			if err := files.Reload(); err != nil {
+				logrus.WithError(err).Warnln("reload certificates failed")
+			}
+		}
+	}()
+}
@@ -0,0 +1,118 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"r-ssh/common"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// certificateIndex is immutable snapshot of certificates, it's replaced as a whole on every change
+type certificateIndex struct {
+	sources  []string
+	bySource map[string]*tls.Certificate
+	// byName maps names of certificates, including wildcard names, to certificates
+	byName map[string]*tls.Certificate
+}
+
+func (i *certificateIndex) with(source string, cert *tls.Certificate) *certificateIndex {
+	index := &certificateIndex{
+		bySource: make(map[string]*tls.Certificate, len(i.bySource)+1),
+		byName:   make(map[string]*tls.Certificate),
+	}
+	index.sources = append(index.sources, i.sources...)
+	if _, ok := i.bySource[source]; !ok {
+		index.sources = append(index.sources, source)
+	}
+	for s, c := range i.bySource {
+		index.bySource[s] = c
+	}
+	index.bySource[source] = cert
+
+	// earlier sources win when certificates share a name
+	for n := len(index.sources) - 1; n >= 0; n-- {
+		c := index.bySource[index.sources[n]]
+		for _, name := range c.Leaf.DNSNames {
+			index.byName[strings.ToLower(name)] = c
+		}
+		if len(c.Leaf.DNSNames) == 0 && c.Leaf.Subject.CommonName != "" {
+			index.byName[strings.ToLower(c.Leaf.Subject.CommonName)] = c
+		}
+	}
+	return index
+}
+
+// Certificates selects certificate by tls server name, the first set certificate is served to clients
+// without matching name. Each source, e.g. a file pair or ACME, replaces its certificate atomically
+type Certificates struct {
+	lock  sync.Mutex
+	index atomic.Value
+}
+
+// LoadKeyPair loads certificate with parsed leaf
+func LoadKeyPair(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// Set replaces certificate of the source, handshakes started afterwards use it
+func (c *Certificates) Set(source string, cert *tls.Certificate) error {
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		withLeaf := *cert
+		withLeaf.Leaf = leaf
+		cert = &withLeaf
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.index.Store(c.current().with(source, cert))
+	return nil
+}
+
+func (c *Certificates) current() *certificateIndex {
+	index, _ := c.index.Load().(*certificateIndex)
+	if index == nil {
+		return &certificateIndex{}
+	}
+	return index
+}
+
+// Get returns certificate with the exact server name, then the wildcard one and the first certificate otherwise
+func (c *Certificates) Get(serverName string) (*tls.Certificate, error) {
+	index := c.current()
+	if len(index.sources) == 0 {
+		return nil, common.ErrCertificateNotReady
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if cert, ok := index.byName[name]; ok {
+		return cert, nil
+	}
+	if dot := strings.IndexByte(name, '.'); dot > 0 {
+		if cert, ok := index.byName["*"+name[dot:]]; ok {
+			return cert, nil
+		}
+	}
+	return index.bySource[index.sources[0]], nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *Certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.Get(hello.ServerName)
+}
+
+func NewCertificates() *Certificates {
+	return &Certificates{}
+}
@@ -0,0 +1,79 @@
+package certs
+
+import (
+	"crypto/tls"
+	"errors"
+	"r-ssh/common"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, names ...string) *tls.Certificate {
+	der, key := selfSigned(t, time.Now().Add(time.Hour), names...)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCertificates_Get(t *testing.T) {
+	certificates := NewCertificates()
+	if _, err := certificates.Get("example.com"); !errors.Is(err, common.ErrCertificateNotReady) {
+		t.Fatalf("expected certificate not ready, got %v", err)
+	}
+
+	public := testCertificate(t, "*.example.com", "example.com")
+	internal := testCertificate(t, "*.example.internal")
+	status := testCertificate(t, "status.example.com")
+	for n, cert := range []*tls.Certificate{public, internal, status} {
+		if err := certificates.Set(string(rune('a'+n)), cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		serverName string
+		expected   *tls.Certificate
+	}{
+		{"app.example.com", public},
+		{"Example.com.", public},
+		{"status.example.com", status},
+		{"app.example.internal", internal},
+		{"a.b.example.internal", public},
+		{"", public},
+	}
+	for _, test := range tests {
+		cert, err := certificates.Get(test.serverName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(cert.Certificate[0]) != string(test.expected.Certificate[0]) {
+			t.Errorf("%q: unexpected certificate %v", test.serverName, cert.Leaf.DNSNames)
+		}
+	}
+}
+
+func TestCertificates_Set(t *testing.T) {
+	certificates := NewCertificates()
+	first := testCertificate(t, "*.example.com")
+	second := testCertificate(t, "*.example.internal")
+	if err := certificates.Set("first", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := certificates.Set("second", second); err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown names get the first source
+	if cert, _ := certificates.Get("other.org"); string(cert.Certificate[0]) != string(first.Certificate[0]) {
+		t.Error("expected the first certificate by default")
+	}
+
+	renewed := testCertificate(t, "*.example.org")
+	if err := certificates.Set("first", renewed); err != nil {
+		t.Fatal(err)
+	}
+	if cert, _ := certificates.Get("app.example.com"); string(cert.Certificate[0]) != string(renewed.Certificate[0]) {
+		t.Error("expected replaced certificate for the former name")
+	}
+	if cert, _ := certificates.Get("app.example.org"); string(cert.Certificate[0]) != string(renewed.Certificate[0]) {
+		t.Error("expected renewed certificate")
+	}
+}
@@ -0,0 +1,37 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"r-ssh/common"
+
+	"golang.org/x/crypto/acme"
+)
+
+// NewClient creates ACME client of the directory, caFile adds roots trusted for the directory, e.g. of local CA
+func NewClient(directoryURL, caFile string) (*acme.Client, error) {
+	client := &acme.Client{DirectoryURL: directoryURL}
+	if caFile == "" {
+		return client, nil
+	}
+
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, common.ErrInvalidCA
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	client.HTTPClient = &http.Client{Transport: transport}
+	return client, nil
+}
@@ -0,0 +1,86 @@
+package certs
+
+import (
+	"r-ssh/common"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type keyPair struct {
+	certFile string
+	keyFile  string
+}
+
+// FileCertificates loads certificate files into Certificates, each pair is a source named by its certificate file.
+// Pair which fails to reload keeps serving its previous certificate, e.g. while only one of the files was renewed
+type FileCertificates struct {
+	pairs        []keyPair
+	certificates *Certificates
+
+	logger *logrus.Entry
+}
+
+func (f *FileCertificates) load(pair keyPair) error {
+	cert, err := LoadKeyPair(pair.certFile, pair.keyFile)
+	if err != nil {
+		return err
+	}
+	if err = f.certificates.Set(pair.certFile, cert); err != nil {
+		return err
+	}
+
+	f.logger.WithField("file", pair.certFile).WithField("names", cert.Leaf.DNSNames).
+		WithField("expires", cert.Leaf.NotAfter).Info("certificate loaded")
+	return nil
+}
+
+// Reload loads all pairs, the first error is returned after trying the rest
+func (f *FileCertificates) Reload() error {
+	var firstErr error
+	for _, pair := range f.pairs {
+		if err := f.load(pair); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Watch reloads pair when its certificate or key file changes
+func (f *FileCertificates) Watch(interval time.Duration) (stop func()) {
+	var stops []func()
+	for _, pair := range f.pairs {
+		pair := pair
+		reload := func() {
+			if err := f.load(pair); err != nil {
+				f.logger.WithError(err).WithField("file", pair.certFile).Warnln("reload certificate failed")
+			}
+		}
+		stops = append(stops, common.WatchFile(pair.certFile, interval, reload), common.WatchFile(pair.keyFile, interval, reload))
+	}
+
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+}
+
+// NewFileCertificates loads certFiles paired with keyFiles by position into certificates
+func NewFileCertificates(certFiles, keyFiles []string, certificates *Certificates) (*FileCertificates, error) {
+	if len(certFiles) != len(keyFiles) {
+		return nil, common.ErrCertificateFilesMismatch
+	}
+
+	files := &FileCertificates{
+		certificates: certificates,
+		logger:       logrus.WithField("component", "certificates"),
+	}
+	for n := range certFiles {
+		files.pairs = append(files.pairs, keyPair{certFile: certFiles[n], keyFile: keyFiles[n]})
+	}
+	if err := files.Reload(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
@@ -0,0 +1,70 @@
+package certs
+
+import (
+	"errors"
+	"path/filepath"
+	"r-ssh/common"
+	"testing"
+	"time"
+)
+
+func writePair(t *testing.T, store *Store, name string, names ...string) {
+	der, key := selfSigned(t, time.Now().Add(time.Hour), names...)
+	if err := store.Save(name, [][]byte{der}, key); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileCertificates_Reload(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePair(t, store, "public", "*.example.com")
+	writePair(t, store, "internal", "*.example.internal")
+
+	certificates := NewCertificates()
+	files, err := NewFileCertificates(
+		[]string{store.CertFile("public"), store.CertFile("internal")},
+		[]string{store.KeyFile("public"), store.KeyFile("internal")},
+		certificates,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, _ := certificates.Get("app.example.internal"); cert.Leaf.DNSNames[0] != "*.example.internal" {
+		t.Errorf("expected internal certificate, got %v", cert.Leaf.DNSNames)
+	}
+
+	writePair(t, store, "public", "*.example.org")
+	if err = files.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cert, _ := certificates.Get("app.example.org"); cert.Leaf.DNSNames[0] != "*.example.org" {
+		t.Errorf("expected reloaded certificate, got %v", cert.Leaf.DNSNames)
+	}
+
+	// key of another certificate fails to load, the previous certificate is kept
+	other, _ := selfSigned(t, time.Now().Add(time.Hour), "*.example.net")
+	_, key := selfSigned(t, time.Now().Add(time.Hour), "*.example.net")
+	if err = store.Save("public", [][]byte{other}, key); err != nil {
+		t.Fatal(err)
+	}
+	if err = files.Reload(); err == nil {
+		t.Error("expected mismatched key error")
+	}
+	if cert, _ := certificates.Get("app.example.org"); cert.Leaf.DNSNames[0] != "*.example.org" {
+		t.Errorf("expected previous certificate, got %v", cert.Leaf.DNSNames)
+	}
+}
+
+func TestNewFileCertificates(t *testing.T) {
+	dir := t.TempDir()
+	_, err := NewFileCertificates([]string{filepath.Join(dir, "a.crt")}, nil, NewCertificates())
+	if !errors.Is(err, common.ErrCertificateFilesMismatch) {
+		t.Errorf("expected files mismatch, got %v", err)
+	}
+	if _, err = NewFileCertificates([]string{filepath.Join(dir, "a.crt")}, []string{filepath.Join(dir, "a.key")}, NewCertificates()); err == nil {
+		t.Error("expected missing file error")
+	}
+}
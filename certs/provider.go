@@ -0,0 +1,10 @@
+package certs
+
+import "context"
+
+// DNSProvider publishes TXT records answering ACME dns-01 challenges. Wildcard and base domain share the record
+// name, so records with other values must be kept
+type DNSProvider interface {
+	Present(ctx context.Context, name, value string) error
+	CleanUp(ctx context.Context, name, value string) error
+}
@@ -0,0 +1,219 @@
+package certs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/big"
+	"net"
+	"r-ssh/common"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	opCodeUpdate = dnsmessage.OpCode(5)
+	// classNone deletes the given record in update section
+	classNone = dnsmessage.Class(254)
+	typeTSIG  = 250
+	tsigFudge = 300
+
+	rfc2136Timeout = 10 * time.Second
+)
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1.":   sha1.New,
+	"hmac-sha256.": sha256.New,
+	"hmac-sha512.": sha512.New,
+}
+
+// RFC2136Provider publishes challenges by dynamic dns updates signed with TSIG
+type RFC2136Provider struct {
+	nameserver string
+	zone       string
+	ttl        uint32
+
+	keyName   string
+	algorithm string
+	secret    []byte
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return strings.ToLower(name)
+	}
+	return strings.ToLower(name) + "."
+}
+
+// appendName appends uncompressed wire form of fully qualified name
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// sign appends TSIG record to packed message, see RFC 8945
+func (r *RFC2136Provider) sign(msg []byte, id uint16, now time.Time) []byte {
+	signed := uint64(now.Unix())
+	timeSigned := []byte{byte(signed >> 40), byte(signed >> 32), byte(signed >> 24), byte(signed >> 16), byte(signed >> 8), byte(signed)}
+
+	mac := hmac.New(tsigAlgorithms[r.algorithm], r.secret)
+	mac.Write(msg)
+	variables := appendName(nil, r.keyName)
+	// class ANY and ttl 0
+	variables = append(variables, 0, 255, 0, 0, 0, 0)
+	variables = appendName(variables, r.algorithm)
+	variables = append(variables, timeSigned...)
+	// fudge, error and empty other data
+	variables = append(variables, byte(tsigFudge>>8), byte(tsigFudge&0xff), 0, 0, 0, 0)
+	mac.Write(variables)
+	sum := mac.Sum(nil)
+
+	rdata := appendName(nil, r.algorithm)
+	rdata = append(rdata, timeSigned...)
+	rdata = append(rdata, byte(tsigFudge>>8), byte(tsigFudge&0xff), byte(len(sum)>>8), byte(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, byte(id>>8), byte(id), 0, 0, 0, 0)
+
+	record := appendName(nil, r.keyName)
+	record = append(record, byte(typeTSIG>>8), byte(typeTSIG&0xff), 0, 255, 0, 0, 0, 0, byte(len(rdata)>>8), byte(len(rdata)))
+	record = append(record, rdata...)
+
+	// one more additional record
+	additionals := binary.BigEndian.Uint16(msg[10:12]) + 1
+	signedMsg := append(append([]byte(nil), msg...), record...)
+	binary.BigEndian.PutUint16(signedMsg[10:12], additionals)
+	return signedMsg
+}
+
+// updateMessage builds update of TXT record, class NONE deletes the record and class INET adds it
+func (r *RFC2136Provider) updateMessage(id uint16, name, value string, class dnsmessage.Class, ttl uint32) ([]byte, error) {
+	zone, err := dnsmessage.NewName(r.zone)
+	if err != nil {
+		return nil, err
+	}
+	recordName, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, err
+	}
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, OpCode: opCodeUpdate})
+	// zone section is the question section of update
+	if err = builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err = builder.Question(dnsmessage.Question{Name: zone, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	// update section is the authority section of update
+	if err = builder.StartAuthorities(); err != nil {
+		return nil, err
+	}
+	header := dnsmessage.ResourceHeader{Name: recordName, Class: class, TTL: ttl}
+	if err = builder.TXTResource(header, dnsmessage.TXTResource{TXT: []string{value}}); err != nil {
+		return nil, err
+	}
+	return builder.Finish()
+}
+
+func (r *RFC2136Provider) update(ctx context.Context, name, value string, class dnsmessage.Class, ttl uint32) error {
+	random, err := rand.Int(rand.Reader, big.NewInt(1<<16))
+	if err != nil {
+		return err
+	}
+	id := uint16(random.Uint64())
+
+	msg, err := r.updateMessage(id, name, value, class, ttl)
+	if err != nil {
+		return err
+	}
+	if r.keyName != "" {
+		msg = r.sign(msg, id, time.Now())
+	}
+
+	dialer := &net.Dialer{Timeout: rfc2136Timeout}
+	conn, err := dialer.DialContext(ctx, "udp", r.nameserver)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(rfc2136Timeout)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	if _, err = conn.Write(msg); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil || header.ID != id || !header.Response {
+			// not an answer to the update
+			continue
+		}
+		if header.RCode != dnsmessage.RCodeSuccess {
+			return fmt.Errorf("%w: %s", common.ErrDNSUpdateFailed, header.RCode)
+		}
+		return nil
+	}
+}
+
+// Present adds TXT record, records with other values are kept
+func (r *RFC2136Provider) Present(ctx context.Context, name, value string) error {
+	return r.update(ctx, name, value, dnsmessage.ClassINET, r.ttl)
+}
+
+// CleanUp removes only the TXT record with value
+func (r *RFC2136Provider) CleanUp(ctx context.Context, name, value string) error {
+	return r.update(ctx, name, value, classNone, 0)
+}
+
+// NewRFC2136Provider creates provider updating zone on nameserver ("host:port"), keyName and base64 secret
+// sign updates with algorithm, e.g. "hmac-sha256", empty keyName sends unsigned updates
+func NewRFC2136Provider(nameserver, zone string, ttl time.Duration, keyName, algorithm, secret string) (*RFC2136Provider, error) {
+	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+		nameserver = net.JoinHostPort(nameserver, "53")
+	}
+
+	provider := &RFC2136Provider{
+		nameserver: nameserver,
+		zone:       fqdn(zone),
+		ttl:        uint32(ttl.Seconds()),
+	}
+	if keyName == "" {
+		return provider, nil
+	}
+
+	provider.keyName = fqdn(keyName)
+	provider.algorithm = fqdn(algorithm)
+	if _, ok := tsigAlgorithms[provider.algorithm]; !ok {
+		return nil, fmt.Errorf("%w: %q", common.ErrUnknownTSIGAlgorithm, algorithm)
+	}
+
+	var err error
+	if provider.secret, err = base64.StdEncoding.DecodeString(secret); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
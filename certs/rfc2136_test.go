@@ -0,0 +1,145 @@
+package certs
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"r-ssh/common"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type updateRecord struct {
+	zone  string
+	name  string
+	class dnsmessage.Class
+	ttl   uint32
+	txt   []string
+	// signed reports the update carries TSIG record
+	signed bool
+}
+
+// serveUpdates answers dns updates with rcode and sends parsed updates to the returned channel
+func serveUpdates(t *testing.T, rcode dnsmessage.RCode) (string, chan updateRecord) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	updates := make(chan updateRecord, 10)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var parser dnsmessage.Parser
+			header, err := parser.Start(buf[:n])
+			if err != nil || header.OpCode != opCodeUpdate {
+				continue
+			}
+			question, err := parser.Question()
+			if err != nil {
+				continue
+			}
+			_ = parser.SkipAllQuestions()
+			_ = parser.SkipAllAnswers()
+			resource, err := parser.Authority()
+			if err != nil {
+				continue
+			}
+			updates <- updateRecord{
+				zone:   question.Name.String(),
+				name:   resource.Header.Name.String(),
+				class:  resource.Header.Class,
+				ttl:    resource.Header.TTL,
+				txt:    resource.Body.(*dnsmessage.TXTResource).TXT,
+				signed: binary.BigEndian.Uint16(buf[10:12]) == 1,
+			}
+
+			builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true, OpCode: opCodeUpdate, RCode: rcode})
+			answer, _ := builder.Finish()
+			_, _ = conn.WriteTo(answer, addr)
+		}
+	}()
+	return conn.LocalAddr().String(), updates
+}
+
+func TestRFC2136Provider_Update(t *testing.T) {
+	nameserver, updates := serveUpdates(t, dnsmessage.RCodeSuccess)
+	provider, err := NewRFC2136Provider(nameserver, "Example.com", time.Minute, "rssh-key", "hmac-sha256", "c2VjcmV0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = provider.Present(context.Background(), "_acme-challenge.example.com", "token"); err != nil {
+		t.Fatal(err)
+	}
+	update := <-updates
+	if update.zone != "example.com." || update.name != "_acme-challenge.example.com." || update.class != dnsmessage.ClassINET ||
+		update.ttl != 60 || len(update.txt) != 1 || update.txt[0] != "token" || !update.signed {
+		t.Errorf("unexpected present update %+v", update)
+	}
+
+	if err = provider.CleanUp(context.Background(), "_acme-challenge.example.com", "token"); err != nil {
+		t.Fatal(err)
+	}
+	update = <-updates
+	if update.class != classNone || update.ttl != 0 || update.txt[0] != "token" {
+		t.Errorf("unexpected clean up update %+v", update)
+	}
+}
+
+func TestRFC2136Provider_Refused(t *testing.T) {
+	nameserver, _ := serveUpdates(t, dnsmessage.RCodeRefused)
+	provider, err := NewRFC2136Provider(nameserver, "example.com", time.Minute, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = provider.Present(context.Background(), "_acme-challenge.example.com", "token")
+	if !errors.Is(err, common.ErrDNSUpdateFailed) {
+		t.Errorf("expected update failure, got %v", err)
+	}
+}
+
+func TestRFC2136Provider_sign(t *testing.T) {
+	provider, err := NewRFC2136Provider("127.0.0.1", "example.com", time.Minute, "rssh-key", "hmac-sha256", "c2VjcmV0c2VjcmV0c2VjcmV0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := provider.updateMessage(4242, "_acme-challenge.example.com", "value123", dnsmessage.ClassINET, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := provider.sign(msg, 4242, time.Unix(1600000000, 0))
+	if binary.BigEndian.Uint16(signed[10:12]) != 1 {
+		t.Fatal("expected one additional record")
+	}
+	// the record ends with mac, original id, error and other length
+	mac := signed[len(signed)-6-32 : len(signed)-6]
+	if expected := "b2cd29a949bc45746ad34919f07d2f6845cd56c01b1688a3b4abccaa2fabfb91"; hex.EncodeToString(mac) != expected {
+		t.Errorf("expected mac %s, got %x", expected, mac)
+	}
+}
+
+func TestNewRFC2136Provider(t *testing.T) {
+	provider, err := NewRFC2136Provider("ns.example.com", "example.com", time.Minute, "", "", "")
+	if err != nil || provider.nameserver != "ns.example.com:53" {
+		t.Errorf("expected default port, got %v %v", provider, err)
+	}
+	if _, err = NewRFC2136Provider("ns.example.com", "example.com", time.Minute, "key", "hmac-md4", "c2VjcmV0"); !errors.Is(err, common.ErrUnknownTSIGAlgorithm) {
+		t.Errorf("expected unknown algorithm, got %v", err)
+	}
+	if _, err = NewRFC2136Provider("ns.example.com", "example.com", time.Minute, "key", "hmac-sha256", "!"); err == nil {
+		t.Error("expected invalid secret")
+	}
+}
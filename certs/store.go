@@ -0,0 +1,126 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const accountKeyFile = "account.key"
+
+// Store keeps certificates as "<name>.crt" chain and "<name>.key" pair in dir, the same layout as CERT_FILE and KEY_FILE
+type Store struct {
+	dir string
+
+	// accountLock makes managers sharing the store generate single account key
+	accountLock sync.Mutex
+}
+
+// writeFile replaces file atomically, so readers never see partial content
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// CertFile returns path of certificate chain
+func (s *Store) CertFile(name string) string {
+	return filepath.Join(s.dir, name+".crt")
+}
+
+// KeyFile returns path of certificate key
+func (s *Store) KeyFile(name string) string {
+	return filepath.Join(s.dir, name+".key")
+}
+
+// Certificate loads stored certificate with parsed leaf, it returns os.ErrNotExist error if it wasn't issued yet
+func (s *Store) Certificate(name string) (*tls.Certificate, error) {
+	return LoadKeyPair(s.CertFile(name), s.KeyFile(name))
+}
+
+// Save stores DER chain and its key, the key is written first so the pair is never loaded with foreign key
+// for longer than the rename takes
+func (s *Store) Save(name string, chain [][]byte, key *ecdsa.PrivateKey) error {
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	var certPEM []byte
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	if err = writeFile(s.KeyFile(name), keyPEM, 0600); err != nil {
+		return err
+	}
+	return writeFile(s.CertFile(name), certPEM, 0644)
+}
+
+// AccountKey loads ACME account key, new key is generated on the first use
+func (s *Store) AccountKey() (crypto.Signer, error) {
+	s.accountLock.Lock()
+	defer s.accountLock.Unlock()
+
+	path := filepath.Join(s.dir, accountKeyFile)
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, os.ErrInvalid
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, writeFile(path, keyPEM, 0600)
+}
+
+// NewStore creates store in dir, the directory is created if missing
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
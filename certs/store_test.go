@@ -0,0 +1,110 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+func selfSigned(t *testing.T, notAfter time.Time, names ...string) ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, key
+}
+
+func TestStore_Certificate(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = store.Certificate("example.com"); !os.IsNotExist(err) {
+		t.Fatalf("expected missing certificate, got %v", err)
+	}
+
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	der, key := selfSigned(t, notAfter, "*.example.com", "example.com")
+	if err = store.Save("example.com", [][]byte{der}, key); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := store.Certificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Leaf.NotAfter.Equal(notAfter) {
+		t.Errorf("expected leaf expiring %v, got %v", notAfter, cert.Leaf.NotAfter)
+	}
+	if info, err := os.Stat(store.KeyFile("example.com")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected private key file, got %v %v", info, err)
+	}
+}
+
+func TestStore_AccountKey(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := store.AccountKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.AccountKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.(*ecdsa.PrivateKey).D.Cmp(loaded.(*ecdsa.PrivateKey).D) != 0 {
+		t.Error("expected the same account key")
+	}
+}
+
+func TestStore_AccountKeyConcurrent(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// managers of all hosts load the key at once on the first start
+	keys := make(chan crypto.Signer, 4)
+	for i := 0; i < cap(keys); i++ {
+		go func() {
+			key, err := store.AccountKey()
+			if err != nil {
+				t.Error(err)
+			}
+			keys <- key
+		}()
+	}
+
+	loaded, err := store.AccountKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < cap(keys); i++ {
+		key := <-keys
+		if key == nil || key.(*ecdsa.PrivateKey).D.Cmp(loaded.(*ecdsa.PrivateKey).D) != 0 {
+			t.Error("expected the stored account key")
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"r-ssh/common"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+)
+
+// DefaultRenewInterval is how often the certificate expiry is checked
+const DefaultRenewInterval = 12 * time.Hour
+
+const (
+	challengePrefix = "_acme-challenge."
+	obtainTimeout   = 10 * time.Minute
+	cleanUpTimeout  = time.Minute
+)
+
+// WildcardManager obtains "*.<host>" certificate covering the host itself by ACME dns-01 challenges
+// and renews it before it expires
+type WildcardManager struct {
+	host     string
+	email    string
+	client   *acme.Client
+	provider DNSProvider
+	store    *Store
+
+	renewBefore      time.Duration
+	propagationDelay time.Duration
+
+	registered bool
+	logger     *logrus.Entry
+}
+
+func (m *WildcardManager) domains() []string {
+	return []string{"*." + m.host, m.host}
+}
+
+// needsRenewal reports certificate is missing or expires within renewBefore
+func (m *WildcardManager) needsRenewal(cert *tls.Certificate, now time.Time) bool {
+	return cert == nil || cert.Leaf == nil || now.Add(m.renewBefore).After(cert.Leaf.NotAfter)
+}
+
+// Certificate returns stored certificate, nil if it wasn't issued yet
+func (m *WildcardManager) Certificate() (*tls.Certificate, error) {
+	cert, err := m.store.Certificate(m.host)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return cert, err
+}
+
+func (m *WildcardManager) register(ctx context.Context) error {
+	if m.registered {
+		return nil
+	}
+
+	key, err := m.store.AccountKey()
+	if err != nil {
+		return err
+	}
+	m.client.Key = key
+
+	account := &acme.Account{}
+	if m.email != "" {
+		account.Contact = []string{"mailto:" + m.email}
+	}
+	if _, err = m.client.Register(ctx, account, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return err
+	}
+	m.registered = true
+	return nil
+}
+
+// authorize presents dns-01 records of pending authorizations, accepts challenges once the records propagate
+// and waits for the CA to validate them. Records are removed afterwards
+func (m *WildcardManager) authorize(ctx context.Context, order *acme.Order) error {
+	type pending struct {
+		url       string
+		challenge *acme.Challenge
+		name      string
+		value     string
+	}
+	var authorizations []pending
+
+	defer func() {
+		cleanUpCtx, cancel := context.WithTimeout(context.Background(), cleanUpTimeout)
+		defer cancel()
+		for _, p := range authorizations {
+			if err := m.provider.CleanUp(cleanUpCtx, p.name, p.value); err != nil {
+				m.logger.WithError(err).WithField("name", p.name).Warnln("clean up challenge record failed")
+			}
+		}
+	}()
+
+	for _, url := range order.AuthzURLs {
+		authz, err := m.client.GetAuthorization(ctx, url)
+		if err != nil {
+			return err
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				challenge = c
+				break
+			}
+		}
+		if challenge == nil {
+			return fmt.Errorf("%w: %s", common.ErrChallengeNotOffered, authz.Identifier.Value)
+		}
+
+		value, err := m.client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return err
+		}
+		name := challengePrefix + authz.Identifier.Value
+		if err = m.provider.Present(ctx, name, value); err != nil {
+			return err
+		}
+		authorizations = append(authorizations, pending{url: url, challenge: challenge, name: name, value: value})
+	}
+	if len(authorizations) == 0 {
+		return nil
+	}
+
+	select {
+	case <-time.After(m.propagationDelay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for _, p := range authorizations {
+		if _, err := m.client.Accept(ctx, p.challenge); err != nil {
+			return err
+		}
+	}
+	for _, p := range authorizations {
+		if _, err := m.client.WaitAuthorization(ctx, p.url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// obtain orders new certificate and stores it
+func (m *WildcardManager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	if err := m.register(ctx); err != nil {
+		return nil, err
+	}
+
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.domains()...))
+	if err != nil {
+		return nil, err
+	}
+	if err = m.authorize(ctx, order); err != nil {
+		return nil, err
+	}
+	if order, err = m.client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: m.domains()}, key)
+	if err != nil {
+		return nil, err
+	}
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.store.Save(m.host, chain, key); err != nil {
+		return nil, err
+	}
+	return m.store.Certificate(m.host)
+}
+
+// Renew returns stored certificate, new one is obtained when it's missing or expires soon
+func (m *WildcardManager) Renew(ctx context.Context) (*tls.Certificate, error) {
+	cert, err := m.Certificate()
+	if err != nil {
+		m.logger.WithError(err).Warnln("load certificate failed")
+	}
+	if !m.needsRenewal(cert, time.Now()) {
+		return cert, nil
+	}
+
+	m.logger.WithField("domains", m.domains()).Infoln("obtaining certificate")
+	return m.obtain(ctx)
+}
+
+// Run renews certificate now and then every interval, onUpdate is called with every newly obtained certificate.
+// Failed renewal is retried on the next tick, the current certificate stays in use meanwhile
+func (m *WildcardManager) Run(interval time.Duration, onUpdate func(*tls.Certificate)) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var last *tls.Certificate
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), obtainTimeout)
+			cert, err := m.Renew(ctx)
+			cancel()
+			switch {
+			case err != nil:
+				m.logger.WithError(err).Errorln("renew certificate failed")
+			case last == nil || !cert.Leaf.Equal(last.Leaf):
+				m.logger.WithField("expires", cert.Leaf.NotAfter).Infoln("certificate updated")
+				last = cert
+				onUpdate(cert)
+			}
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// NewWildcardManager creates manager of host certificate, renewBefore is how long before expiry the certificate
+// is renewed and propagationDelay how long the CA waits for challenge records to appear on nameservers.
+// The manager sets account key of client, the client can't be shared with other managers
+func NewWildcardManager(client *acme.Client, email, host string, provider DNSProvider, store *Store, renewBefore, propagationDelay time.Duration) *WildcardManager {
+	return &WildcardManager{
+		host:             host,
+		email:            email,
+		client:           client,
+		provider:         provider,
+		store:            store,
+		renewBefore:      renewBefore,
+		propagationDelay: propagationDelay,
+		logger:           logrus.WithField("component", "wildcard-cert"),
+	}
+}
@@ -0,0 +1,29 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestWildcardManager_needsRenewal(t *testing.T) {
+	manager := NewWildcardManager(nil, "", "example.com", nil, nil, 30*24*time.Hour, 0)
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		cert     *tls.Certificate
+		expected bool
+	}{
+		{"missing", nil, true},
+		{"fresh", &tls.Certificate{Leaf: &x509.Certificate{NotAfter: now.Add(60 * 24 * time.Hour)}}, false},
+		{"expiring", &tls.Certificate{Leaf: &x509.Certificate{NotAfter: now.Add(10 * 24 * time.Hour)}}, true},
+		{"expired", &tls.Certificate{Leaf: &x509.Certificate{NotAfter: now.Add(-time.Hour)}}, true},
+	}
+	for _, test := range tests {
+		if actual := manager.needsRenewal(test.cert, now); actual != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"net"
+	"path"
+	"strings"
+)
+
+const patternDelimiter = ","
+const patternNegation = "!"
+
+func matchAddressPattern(ip net.IP, pattern string) bool {
+	if strings.Contains(pattern, "/") {
+		_, network, err := net.ParseCIDR(pattern)
+		return err == nil && network.Contains(ip)
+	}
+
+	matched, err := path.Match(pattern, ip.String())
+	return err == nil && matched
+}
+
+// MatchAddressList matches ip against an OpenSSH style pattern list ("10.0.0.0/8,192.168.1.*,!192.168.1.1").
+// A matching negated pattern always rejects the address.
+func MatchAddressList(ip net.IP, patterns string) bool {
+	if ip == nil {
+		return false
+	}
+
+	matched := false
+	for _, pattern := range strings.Split(patterns, patternDelimiter) {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		negated := strings.HasPrefix(pattern, patternNegation)
+		if negated {
+			pattern = strings.TrimPrefix(pattern, patternNegation)
+		}
+
+		if !matchAddressPattern(ip, pattern) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+func AddrIP(addr net.Addr) net.IP {
+	if addr == nil {
+		return nil
+	}
+
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
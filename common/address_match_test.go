@@ -0,0 +1,29 @@
+package common
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMatchAddressList(t *testing.T) {
+	tests := []struct {
+		name     string
+		ip       string
+		patterns string
+		want     bool
+	}{
+		{name: "Exact", ip: "192.168.1.1", patterns: "192.168.1.1", want: true},
+		{name: "Wildcard", ip: "192.168.1.20", patterns: "192.168.1.*", want: true},
+		{name: "CIDR", ip: "10.20.30.40", patterns: "127.0.0.1,10.0.0.0/8", want: true},
+		{name: "Negated", ip: "10.0.0.1", patterns: "10.0.0.0/8,!10.0.0.1", want: false},
+		{name: "No match", ip: "172.16.0.1", patterns: "10.0.0.0/8", want: false},
+		{name: "IPv6", ip: "2001:db8::1", patterns: "2001:db8::/32", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchAddressList(net.ParseIP(tt.ip), tt.patterns); got != tt.want {
+				t.Errorf("MatchAddressList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -12,6 +12,8 @@ const BannerMessage = `
 `
 const MultipleSessionMessage = "Multiple sessions not allowed"
 const ExtensionFingerprint = "pubkey-fp"
+const ExtensionLegacyFingerprint = "pubkey-legacy-fp"
+const ExtensionGrant = "grant-id"
 
 const HostKeySize = 2048
 const HostKeyFilePerm = 600
@@ -3,9 +3,51 @@ package common
 import "errors"
 
 var ErrAuthNotAllowed = errors.New("auth not allowed")
+var ErrUnknownCertificateAuthority = errors.New("certificate signed by unknown authority")
+var ErrNoCertificatePrincipals = errors.New("certificate has no principals")
+var ErrSourceAddressNotAllowed = errors.New("source address not allowed")
+var ErrPortForwardingNotPermitted = errors.New("port forwarding not permitted")
+var ErrUnexpectedAuthResponse = errors.New("unexpected auth response")
+var ErrInvalidHtpasswdLine = errors.New("invalid htpasswd line")
+var ErrInvalidAuthChain = errors.New("invalid auth chain")
 var ErrHostKeyIsDirectory = errors.New("host key is directory")
+var ErrUnknownFingerprintScheme = errors.New("unknown fingerprint scheme")
+var ErrInvalidFingerprint = errors.New("invalid fingerprint")
+var ErrInvalidIdentity = errors.New("identity must be lowercase letters, digits and underscores")
 
 var ErrUnknownRequestType = errors.New("unknown request type")
 var ErrForwardAlreadyBinded = errors.New("forward already binded")
 var ErrForwardNotFound = errors.New("forward not found")
 var ErrPortNotAllowed = errors.New("port not allowed")
+var ErrForwardLimitExceeded = errors.New("forward limit exceeded")
+var ErrTargetNotAllowed = errors.New("target not allowed")
+var ErrFlagNotAllowed = errors.New("flag not allowed")
+var ErrSubdomainNotAllowed = errors.New("subdomain not allowed")
+var ErrNameTaken = errors.New("name owned by another identity")
+var ErrNameNotFound = errors.New("name not found")
+var ErrTooManyNames = errors.New("too many names")
+var ErrTCPForwardDisabled = errors.New("tcp forwarding disabled")
+var ErrNoFreePort = errors.New("no free port")
+var ErrTooManyPorts = errors.New("too many tcp ports")
+var ErrInvalidPortRange = errors.New("invalid port range")
+var ErrDirectTCPIPDisabled = errors.New("direct-tcpip disabled")
+var ErrDirectNotAllowed = errors.New("direct-tcpip not allowed")
+var ErrPoolNotAllowed = errors.New("pool not allowed")
+var ErrUpstreamTimeout = errors.New("upstream timeout")
+var ErrHostNotAllowed = errors.New("host not allowed")
+var ErrCustomDomainsDisabled = errors.New("custom domains disabled")
+var ErrInvalidDomain = errors.New("invalid domain")
+var ErrDomainTaken = errors.New("domain owned by another identity")
+var ErrDomainNotVerified = errors.New("domain not verified")
+var ErrDomainNotFound = errors.New("domain not found")
+var ErrTooManyDomainClaims = errors.New("too many pending domain claims")
+var ErrInvalidCA = errors.New("invalid ca certificate")
+var ErrUnknownDNSProvider = errors.New("unknown dns provider")
+var ErrUnknownTSIGAlgorithm = errors.New("unknown tsig algorithm")
+var ErrDNSUpdateFailed = errors.New("dns update failed")
+var ErrChallengeNotOffered = errors.New("dns-01 challenge not offered")
+var ErrCertificateNotReady = errors.New("certificate not ready")
+var ErrCertificateFilesMismatch = errors.New("every certificate file needs key file")
+
+var ErrInvalidKRL = errors.New("invalid key revocation list")
+var ErrKeyRevoked = errors.New("key revoked")
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"os"
+	"time"
+)
+
+const DefaultWatchInterval = 5 * time.Second
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size()}
+}
+
+func (f fileState) equal(other fileState) bool {
+	return f.size == other.size && f.modTime.Equal(other.modTime)
+}
+
+// WatchFile polls path and calls onChange every time its modification time or size changes.
+// Polling is used instead of inotify so that atomic renames and symlink swaps (e.g. k8s ConfigMap) are picked up.
+func WatchFile(path string, interval time.Duration, onChange func()) (stop func()) {
+	done := make(chan struct{})
+	last := statFile(path)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				current := statFile(path)
+				if current.equal(last) {
+					continue
+				}
+				last = current
+				onChange()
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
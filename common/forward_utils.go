@@ -1,6 +1,8 @@
 package common
 
 import (
+	"net"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -11,13 +13,40 @@ const flagDelimiter = "+"
 const (
 	httpsFlag         = 's'
 	rewriteOriginFlag = 'o'
+	tcpFlag           = 't'
+	passthroughFlag   = 'p'
+	http2Flag         = 'h'
+	customDomainFlag  = 'd'
+
+	roundRobinFlag       = 'r'
+	leastConnectionsFlag = 'l'
+	stickyFlag           = 'c'
 )
 
 var allowedCharsRegexp = regexp.MustCompile("[^a-zA-Z0-9]")
+var nameRegexp = regexp.MustCompile("^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$")
+
+// reservedNames are served by the application itself
+var reservedNames = map[string]struct{}{
+	"status": {},
+}
 
 type ForwardFlags struct {
 	Https         bool
 	RewriteOrigin bool
+	// TCP forwards raw tcp from allocated public port instead of http
+	TCP bool
+	// Passthrough forwards encrypted tls stream selected by SNI, tls is terminated by the client
+	Passthrough bool
+	// HTTP2 speaks h2 to the target, h2c without https flag
+	HTTP2 bool
+	// CustomDomain serves the forward on its address, e.g. on domain with CNAME to the application host
+	CustomDomain bool
+
+	// RoundRobin, LeastConnections and Sticky let several sessions of one identity serve the same subdomain
+	RoundRobin       bool
+	LeastConnections bool
+	Sticky           bool
 }
 
 type ForwardInfo struct {
@@ -27,6 +56,73 @@ type ForwardInfo struct {
 	Port    uint32
 
 	Subdomain string
+	// Name is set for forwards to reserved names, Subdomain equals the name then
+	Name string
+	// SocketPath is set for unix socket forwards, Address equals the path then
+	SocketPath string
+	// Hosts are base domains the forward is published under, custom domains have none
+	Hosts []string
+}
+
+// Bind returns forward address as requested by the client
+func (f *ForwardInfo) Bind() string {
+	if f.SocketPath != "" {
+		return f.SocketPath
+	}
+	return net.JoinHostPort(f.Address, strconv.Itoa(int(f.Port)))
+}
+
+// PublishedOn reports the forward is served as subdomain of base domain host
+func (f *ForwardInfo) PublishedOn(host string) bool {
+	for _, published := range f.Hosts {
+		if published == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Flags returns enabled flags in the same form they are passed in the address
+func (f *ForwardFlags) Flags() string {
+	flags := ""
+	if f.Https {
+		flags += string(httpsFlag)
+	}
+	if f.RewriteOrigin {
+		flags += string(rewriteOriginFlag)
+	}
+	if f.TCP {
+		flags += string(tcpFlag)
+	}
+	if f.Passthrough {
+		flags += string(passthroughFlag)
+	}
+	if f.HTTP2 {
+		flags += string(http2Flag)
+	}
+	if f.CustomDomain {
+		flags += string(customDomainFlag)
+	}
+	return flags + f.PoolFlags()
+}
+
+// PoolFlags returns flags of pool mode, all members of the pool must use the same ones
+func (f *ForwardFlags) PoolFlags() string {
+	flags := ""
+	if f.RoundRobin {
+		flags += string(roundRobinFlag)
+	}
+	if f.LeastConnections {
+		flags += string(leastConnectionsFlag)
+	}
+	if f.Sticky {
+		flags += string(stickyFlag)
+	}
+	return flags
+}
+
+func (f *ForwardFlags) Pooled() bool {
+	return f.RoundRobin || f.LeastConnections || f.Sticky
 }
 
 var defaultFlags = &ForwardFlags{
@@ -34,7 +130,7 @@ var defaultFlags = &ForwardFlags{
 	RewriteOrigin: false,
 }
 
-func makeSubdomain(fingerprint, host string, port uint32) string {
+func makeSubdomain(identity, host string, port uint32) string {
 	prefix := ""
 
 	if host != DefaultForwardAddr {
@@ -45,7 +141,7 @@ func makeSubdomain(fingerprint, host string, port uint32) string {
 		prefix += strconv.Itoa(int(port)) + "-"
 	}
 
-	return prefix + fingerprint
+	return prefix + identity
 }
 
 func parseFlags(host string) (*ForwardFlags, string) {
@@ -58,16 +154,125 @@ func parseFlags(host string) (*ForwardFlags, string) {
 	return &ForwardFlags{
 		Https:         strings.ContainsRune(flags, httpsFlag),
 		RewriteOrigin: strings.ContainsRune(flags, rewriteOriginFlag),
+		TCP:           strings.ContainsRune(flags, tcpFlag),
+		Passthrough:   strings.ContainsRune(flags, passthroughFlag),
+		HTTP2:         strings.ContainsRune(flags, http2Flag),
+		CustomDomain:  strings.ContainsRune(flags, customDomainFlag),
+
+		RoundRobin:       strings.ContainsRune(flags, roundRobinFlag),
+		LeastConnections: strings.ContainsRune(flags, leastConnectionsFlag),
+		Sticky:           strings.ContainsRune(flags, stickyFlag),
 	}, parts[0]
 }
 
-func BuildForwardInfo(fingerprint, address string, port uint32) *ForwardInfo {
+// ParsePortRange parses "min-max" range of ports
+func ParsePortRange(portRange string) (int, int, error) {
+	parts := strings.Split(portRange, "-")
+	if len(parts) != 2 {
+		return 0, 0, ErrInvalidPortRange
+	}
+
+	min, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 16)
+	if err != nil {
+		return 0, 0, ErrInvalidPortRange
+	}
+	max, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 16)
+	if err != nil || min == 0 || max < min {
+		return 0, 0, ErrInvalidPortRange
+	}
+	return int(min), int(max), nil
+}
+
+// IsName reports whether host may be reserved as a subdomain name, i.e. it is single lowercase dns label
+// which doesn't look like a fingerprint or subdomain of a key ("8080-<fingerprint>")
+func IsName(host string) bool {
+	if host == DefaultForwardAddr || !nameRegexp.MatchString(host) {
+		return false
+	}
+	if _, ok := reservedNames[host]; ok {
+		return false
+	}
+	_, err := NormalizeFingerprint(NameSuffix(host))
+	return err != nil
+}
+
+// NameSuffix returns the last "-" separated part of name, it is identity label when name has shape of
+// subdomain derived from identity
+func NameSuffix(name string) string {
+	return name[strings.LastIndexByte(name, '-')+1:]
+}
+
+// IsDomain reports whether host is lowercase dns name of at least two labels, ip addresses are not domains
+func IsDomain(host string) bool {
+	labels := strings.Split(host, ".")
+	if len(labels) < 2 || len(host) > 253 {
+		return false
+	}
+	for _, label := range labels {
+		if !nameRegexp.MatchString(label) {
+			return false
+		}
+	}
+	_, err := strconv.Atoi(labels[len(labels)-1])
+	return err != nil
+}
+
+// BuildDomainForwardInfo creates forward served on custom domain, the target gets the domain as host
+func BuildDomainForwardInfo(address string, port uint32) *ForwardInfo {
+	flags, domain := parseFlags(address)
+	domain = strings.ToLower(domain)
+	return &ForwardInfo{
+		ForwardFlags: flags,
+		Port:         port,
+		Address:      address,
+		Host:         domain,
+		Subdomain:    domain,
+	}
+}
+
+// BuildNamedForwardInfo creates forward to reserved name, requests are sent to the default host
+func BuildNamedForwardInfo(address string, port uint32) *ForwardInfo {
+	flags, name := parseFlags(address)
+	return &ForwardInfo{
+		ForwardFlags: flags,
+		Port:         port,
+		Address:      address,
+		Host:         DefaultForwardAddr,
+		Subdomain:    name,
+		Name:         name,
+	}
+}
+
+func BuildForwardInfo(identity, address string, port uint32) *ForwardInfo {
 	flags, host := parseFlags(address)
 	return &ForwardInfo{
 		ForwardFlags: flags,
 		Port:         port,
 		Address:      address,
 		Host:         host,
-		Subdomain:    makeSubdomain(fingerprint, host, port),
+		Subdomain:    makeSubdomain(identity, host, port),
 	}
 }
+
+// SocketName returns name of unix socket without directory and extension, e.g. "myapp" for "/run/myapp.sock"
+func SocketName(socketPath string) string {
+	name := filepath.Base(socketPath)
+	return strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+// BuildSocketForwardInfo derives subdomain of unix socket forward from the socket name, flags are part of the name
+func BuildSocketForwardInfo(identity, socketPath string) *ForwardInfo {
+	info := BuildForwardInfo(identity, SocketName(socketPath), DefaultForwardPort)
+	info.Address = socketPath
+	info.Host = DefaultForwardAddr
+	info.Port = 0
+	info.SocketPath = socketPath
+	return info
+}
+
+func BuildNamedSocketForwardInfo(socketPath string) *ForwardInfo {
+	info := BuildNamedForwardInfo(SocketName(socketPath), 0)
+	info.Address = socketPath
+	info.SocketPath = socketPath
+	return info
+}
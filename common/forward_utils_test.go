@@ -89,6 +89,17 @@ func TestBuildForwardInfo(t *testing.T) {
 				Subdomain: "test-111-f",
 			},
 		},
+		{
+			name: "HTTP2",
+			args: args{fingerprint: "f", host: "localhost" + flagDelimiter + string(http2Flag), port: 80},
+			want: &ForwardInfo{
+				ForwardFlags: &ForwardFlags{HTTP2: true},
+				Address:      "localhost" + flagDelimiter + string(http2Flag),
+				Host:         "localhost",
+				Port:         80,
+				Subdomain:    "f",
+			},
+		},
 		{
 			name: "Illegal Chars",
 			args: args{fingerprint: "f", host: "test%^&*()=", port: 111},
@@ -109,3 +120,110 @@ func TestBuildForwardInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestIsName(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "myapp", want: true},
+		{host: "my-app2", want: true},
+		{host: DefaultForwardAddr, want: false},
+		{host: "status", want: false},
+		{host: "MyApp", want: false},
+		{host: "-app", want: false},
+		{host: "app.example.com", want: false},
+		{host: "", want: false},
+		{host: "0123456789abcdef0123456789abcdef", want: false},
+		{host: "abcdefghijklmnopqrstuvwxyz", want: false},
+		{host: "8080-0123456789abcdef0123456789abcdef", want: false},
+		{host: "api-8080-abcdefghijklmnopqrstuvwxyz", want: false},
+	}
+	for _, tt := range tests {
+		if got := IsName(tt.host); got != tt.want {
+			t.Errorf("IsName(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestIsDomain(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{host: "demo.customer.com", want: true},
+		{host: "customer.com", want: true},
+		{host: "myapp", want: false},
+		{host: "Demo.customer.com", want: false},
+		{host: "demo..com", want: false},
+		{host: "demo.customer.com.", want: false},
+		{host: "192.168.0.1", want: false},
+		{host: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := IsDomain(tt.host); got != tt.want {
+			t.Errorf("IsDomain(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestBuildDomainForwardInfo(t *testing.T) {
+	want := &ForwardInfo{
+		ForwardFlags: &ForwardFlags{CustomDomain: true},
+		Address:      "Demo.Customer.com" + flagDelimiter + string(customDomainFlag),
+		Host:         "demo.customer.com",
+		Port:         80,
+		Subdomain:    "demo.customer.com",
+	}
+	if got := BuildDomainForwardInfo(want.Address, want.Port); !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildDomainForwardInfo() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildNamedForwardInfo(t *testing.T) {
+	want := &ForwardInfo{
+		ForwardFlags: &ForwardFlags{Https: true},
+		Address:      "myapp" + flagDelimiter + string(httpsFlag),
+		Host:         DefaultForwardAddr,
+		Port:         8080,
+		Subdomain:    "myapp",
+		Name:         "myapp",
+	}
+	if got := BuildNamedForwardInfo(want.Address, want.Port); !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildNamedForwardInfo() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildSocketForwardInfo(t *testing.T) {
+	want := &ForwardInfo{
+		ForwardFlags: &ForwardFlags{Https: true},
+		Address:      "/run/MyApp" + flagDelimiter + string(httpsFlag) + ".sock",
+		Host:         DefaultForwardAddr,
+		Subdomain:    "myapp-f",
+		SocketPath:   "/run/MyApp" + flagDelimiter + string(httpsFlag) + ".sock",
+	}
+	got := BuildSocketForwardInfo("f", want.Address)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildSocketForwardInfo() = %v, want %v", got, want)
+	}
+	if got.Bind() != want.SocketPath {
+		t.Errorf("Bind() = %s, want %s", got.Bind(), want.SocketPath)
+	}
+
+	named := BuildNamedSocketForwardInfo("/tmp/myapp.sock")
+	if named.Subdomain != "myapp" || named.Name != "myapp" || named.SocketPath != "/tmp/myapp.sock" {
+		t.Errorf("BuildNamedSocketForwardInfo() = %v", named)
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	if min, max, err := ParsePortRange("10000 - 10100"); err != nil || min != 10000 || max != 10100 {
+		t.Errorf("ParsePortRange() = %d, %d, %v", min, max, err)
+	}
+
+	for _, portRange := range []string{"", "10000", "0-10", "20-10", "1-70000", "a-b"} {
+		if _, _, err := ParsePortRange(portRange); err != ErrInvalidPortRange {
+			t.Errorf("ParsePortRange(%q) error = %v, want %v", portRange, err, ErrInvalidPortRange)
+		}
+	}
+}
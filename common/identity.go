@@ -0,0 +1,59 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Identity sources, identity is "<source>:<label>". Subdomains of labels other than fingerprints start with the
+// source ("cert_alice", "pw_alice"), so equal labels of different sources never own each other's forwards,
+// names or sessions
+const (
+	IdentityKey         = "key"
+	IdentityCertificate = "cert"
+	IdentityPassword    = "pw"
+	IdentityCallout     = "http"
+)
+
+// identityLabelRegexp leaves out "-", it separates address and port prefixes from the label in subdomains.
+// Labels are short enough to fit dns label with the longest source prefix
+var identityLabelRegexp = regexp.MustCompile("^[a-z0-9_]{1,58}$")
+
+// KeyIdentity is identity of key without certificate or other identity
+func KeyIdentity(fingerprint string) string {
+	return IdentityKey + ":" + fingerprint
+}
+
+// IdentitySource returns part of identity naming its source
+func IdentitySource(identity string) string {
+	if n := strings.IndexByte(identity, ':'); n >= 0 {
+		return identity[:n]
+	}
+	return ""
+}
+
+// IdentityLabel returns part of subdomains derived from identity, fingerprints of keys are used as they are,
+// other labels are prefixed with the source. Fingerprints have no "_", so labels never collide
+func IdentityLabel(identity string) string {
+	n := strings.IndexByte(identity, ':')
+	if n < 0 {
+		return identity
+	}
+	if identity[:n] == IdentityKey {
+		return identity[n+1:]
+	}
+	return identity[:n] + "_" + identity[n+1:]
+}
+
+// SanitizeIdentity makes identity of source from name (e.g. certificate principal). Names are not rewritten,
+// so they can't collide, ones which aren't usable in subdomain as they are or look like fingerprints are rejected
+func SanitizeIdentity(source, name string) (string, error) {
+	if !identityLabelRegexp.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidIdentity, name)
+	}
+	if _, err := NormalizeFingerprint(name); err == nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidIdentity, name)
+	}
+	return source + ":" + name, nil
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeIdentity(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{name: "alice_b", source: IdentityCertificate, want: "cert:alice_b"},
+		{name: "alice", source: IdentityPassword, want: "pw:alice"},
+		{name: "Alice", source: IdentityPassword, wantErr: true},
+		{name: "alice.b", source: IdentityCertificate, wantErr: true},
+		{name: "alice-b", source: IdentityCertificate, wantErr: true},
+		{name: "", source: IdentityCallout, wantErr: true},
+		{name: "0123456789abcdef0123456789abcdef", source: IdentityPassword, wantErr: true},
+		{name: "abcdefghijklmnopqrstuvwxyz", source: IdentityPassword, wantErr: true},
+		{name: strings.Repeat("a", 59), source: IdentityCallout, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SanitizeIdentity(tt.source, tt.name)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidIdentity) {
+					t.Errorf("SanitizeIdentity() error = %v, want ErrInvalidIdentity", err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("SanitizeIdentity() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentityLabel(t *testing.T) {
+	if got := IdentityLabel(KeyIdentity("0123456789abcdef0123456789abcdef")); got != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("IdentityLabel() = %q", got)
+	}
+	if got := IdentityLabel("cert:alice"); got != "cert_alice" {
+		t.Errorf("IdentityLabel() = %q", got)
+	}
+	if IdentityLabel("cert:alice") == IdentityLabel("pw:alice") || IdentityLabel("pw:cert_alice") == IdentityLabel("cert:alice") {
+		t.Error("IdentityLabel() of different sources collide")
+	}
+	if got := IdentitySource("cert:alice"); got != IdentityCertificate {
+		t.Errorf("IdentitySource() = %q", got)
+	}
+}
@@ -0,0 +1,30 @@
+package common
+
+import "io"
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+func copyHalf(dst, src io.ReadWriteCloser, done chan<- struct{}) {
+	_, _ = io.Copy(dst, src)
+	if closer, ok := dst.(closeWriter); ok {
+		_ = closer.CloseWrite()
+	} else {
+		// without half close the other direction would never finish
+		_ = dst.Close()
+	}
+	done <- struct{}{}
+}
+
+// Pipe copies data in both directions until both of them finish, then closes a and b
+func Pipe(a, b io.ReadWriteCloser) {
+	defer a.Close()
+	defer b.Close()
+
+	done := make(chan struct{}, 2)
+	go copyHalf(a, b, done)
+	go copyHalf(b, a, done)
+	<-done
+	<-done
+}
@@ -2,12 +2,97 @@ package common
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/hex"
+	"strings"
 
 	"golang.org/x/crypto/ssh"
 )
 
+type FingerprintScheme string
+
+const (
+	FingerprintMD5    FingerprintScheme = "md5"
+	FingerprintSHA256 FingerprintScheme = "sha256"
+)
+
+// 26 base32 characters keep 130 bits of the digest and leave room for prefixes within the 63 chars DNS label limit
+const sha256FingerprintLength = 26
+
+const (
+	md5Prefix    = "MD5:"
+	sha256Prefix = "SHA256:"
+)
+
+var fingerprintEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func ParseFingerprintScheme(scheme string) (FingerprintScheme, error) {
+	switch FingerprintScheme(strings.ToLower(scheme)) {
+	case FingerprintMD5:
+		return FingerprintMD5, nil
+	case FingerprintSHA256:
+		return FingerprintSHA256, nil
+	default:
+		return "", ErrUnknownFingerprintScheme
+	}
+}
+
+func (f FingerprintScheme) Fingerprint(pubKey ssh.PublicKey) string {
+	if f == FingerprintSHA256 {
+		return GetSHA256Fingerprint(pubKey)
+	}
+	return GetFingerprint(pubKey)
+}
+
+// GetFingerprint returns legacy hex encoded md5 fingerprint
 func GetFingerprint(pubKey ssh.PublicKey) string {
 	fingerprint := md5.Sum(pubKey.Marshal())
 	return hex.EncodeToString(fingerprint[:])
 }
+
+func encodeSHA256Fingerprint(digest []byte) string {
+	return strings.ToLower(fingerprintEncoding.EncodeToString(digest))[:sha256FingerprintLength]
+}
+
+// GetSHA256Fingerprint returns DNS safe truncated base32 sha256 fingerprint
+func GetSHA256Fingerprint(pubKey ssh.PublicKey) string {
+	fingerprint := sha256.Sum256(pubKey.Marshal())
+	return encodeSHA256Fingerprint(fingerprint[:])
+}
+
+// KeyFingerprints returns fingerprints of pubKey in every supported scheme
+func KeyFingerprints(pubKey ssh.PublicKey) []string {
+	return []string{GetFingerprint(pubKey), GetSHA256Fingerprint(pubKey)}
+}
+
+// NormalizeFingerprint converts fingerprint printed by ssh-keygen ("SHA256:...", "MD5:aa:bb:..", "aa:bb:...")
+// or produced by any FingerprintScheme to the form returned by KeyFingerprints
+func NormalizeFingerprint(fingerprint string) (string, error) {
+	fingerprint = strings.TrimSpace(fingerprint)
+
+	if strings.HasPrefix(fingerprint, sha256Prefix) {
+		digest, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(strings.TrimPrefix(fingerprint, sha256Prefix), "="))
+		if err != nil {
+			return "", err
+		}
+		if len(digest) != sha256.Size {
+			return "", ErrInvalidFingerprint
+		}
+		return encodeSHA256Fingerprint(digest), nil
+	}
+
+	fingerprint = strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(fingerprint, md5Prefix), ":", ""))
+	switch len(fingerprint) {
+	case hex.EncodedLen(md5.Size):
+		if _, err := hex.DecodeString(fingerprint); err != nil {
+			return "", err
+		}
+		return fingerprint, nil
+	case sha256FingerprintLength:
+		return fingerprint, nil
+	default:
+		return "", ErrInvalidFingerprint
+	}
+}
@@ -18,3 +18,53 @@ func TestGetFingerprint(t *testing.T) {
 		t.Errorf("GetFingerprint(), want: %s, got: %s", want, got)
 	}
 }
+
+func TestNormalizeFingerprint(t *testing.T) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQCRgSzQfz1CpJYDNIsOMgBqdjcXKdtR4hBMspWvoVLTU7MEgJOxgscPA1bEDd4OiE/nbDm8hg8edUj6dePniI0DtgZ6bu5YZ9QKsJOmpwenW+fv+IsmJAJftsW55Z4DFlS8G4eyIju23RYojDXZ7UYz2RAGdbo1dVDUTRr32JUY76gl0MTi74qupup/OWDShgGrhglvbRRSBMGIGfK681N//0VON3YKDG6GxZyrPFVjbn9dTa2JDzuzpn7D0e9ZoVL4LEApnlQkNf8t7ueOka7azGTdE5AcM9N5bmidpoditEPoq0cdu2iYeuxS0N+v5wNowvF4nHLC/6uTfesSsp8F"))
+	if err != nil {
+		panic(err)
+	}
+
+	tests := []struct {
+		name        string
+		fingerprint string
+		want        string
+	}{
+		{name: "OpenSSH SHA256", fingerprint: ssh.FingerprintSHA256(publicKey), want: GetSHA256Fingerprint(publicKey)},
+		{name: "OpenSSH MD5", fingerprint: "MD5:" + ssh.FingerprintLegacyMD5(publicKey), want: GetFingerprint(publicKey)},
+		{name: "Colon MD5", fingerprint: ssh.FingerprintLegacyMD5(publicKey), want: GetFingerprint(publicKey)},
+		{name: "Hex MD5", fingerprint: GetFingerprint(publicKey), want: GetFingerprint(publicKey)},
+		{name: "DNS SHA256", fingerprint: GetSHA256Fingerprint(publicKey), want: GetSHA256Fingerprint(publicKey)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeFingerprint(tt.fingerprint)
+			if err != nil {
+				t.Fatalf("NormalizeFingerprint() error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeFingerprint() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NormalizeFingerprint("SHA256:invalid"); err == nil {
+		t.Error("NormalizeFingerprint() must fail for invalid fingerprint")
+	}
+}
+
+func TestFingerprintScheme_Fingerprint(t *testing.T) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQCRgSzQfz1CpJYDNIsOMgBqdjcXKdtR4hBMspWvoVLTU7MEgJOxgscPA1bEDd4OiE/nbDm8hg8edUj6dePniI0DtgZ6bu5YZ9QKsJOmpwenW+fv+IsmJAJftsW55Z4DFlS8G4eyIju23RYojDXZ7UYz2RAGdbo1dVDUTRr32JUY76gl0MTi74qupup/OWDShgGrhglvbRRSBMGIGfK681N//0VON3YKDG6GxZyrPFVjbn9dTa2JDzuzpn7D0e9ZoVL4LEApnlQkNf8t7ueOka7azGTdE5AcM9N5bmidpoditEPoq0cdu2iYeuxS0N+v5wNowvF4nHLC/6uTfesSsp8F"))
+	if err != nil {
+		panic(err)
+	}
+
+	if got := FingerprintMD5.Fingerprint(publicKey); got != GetFingerprint(publicKey) {
+		t.Errorf("md5 Fingerprint() = %s", got)
+	}
+
+	got := FingerprintSHA256.Fingerprint(publicKey)
+	if len(got) != sha256FingerprintLength || strings.ToLower(got) != got {
+		t.Errorf("sha256 Fingerprint() = %s is not dns safe", got)
+	}
+}
@@ -1,22 +1,84 @@
 package main
 
+import "time"
+
 type Configuration struct {
-	Host string `required:"true"`
+	// Host lists base domains of tunnels, the first one is the application host
+	Host []string `required:"true"`
 
 	WebEndpoint    string `default:"0.0.0.0:80" split_words:"true"`
 	SslWebEndpoint string `default:"0.0.0.0:443" split_words:"true"`
 	SslRedirect    bool   `split_words:"true" default:"true"`
 
-	CertFile string `split_words:"true"`
-	KeyFile  string `split_words:"true"`
+	CertFile []string `split_words:"true"`
+	KeyFile  []string `split_words:"true"`
 
 	SSHEndpoint string `default:"0.0.0.0:22" split_words:"true"`
 	HostKey     string `required:"true" split_words:"true"`
 
 	PublicKeyWhitelist []string `split_words:"true"`
+	AuthorizedKeysFile string   `split_words:"true"`
+	UserCaKeys         string   `split_words:"true"`
+	HtpasswdFile       string   `split_words:"true"`
+	RevokedKeysFile    string   `split_words:"true"`
+
+	AuthURL      string        `split_words:"true"`
+	AuthTimeout  time.Duration `split_words:"true" default:"5s"`
+	AuthCacheTTL time.Duration `split_words:"true" default:"1m"`
+
+	AuthChain string `split_words:"true"`
+
+	PolicyFile   string `split_words:"true"`
+	Policy       string
+	HostPolicies map[string]string `split_words:"true"`
+
+	MaxAuthTries   int           `split_words:"true" default:"6"`
+	BanMaxFailures int           `split_words:"true" default:"0"`
+	BanWindow      time.Duration `split_words:"true" default:"10m"`
+	BanDuration    time.Duration `split_words:"true" default:"1h"`
+
+	AdminToken string `split_words:"true"`
+
+	AuditLogFile string `split_words:"true"`
+	NamesFile    string `split_words:"true"`
+	MaxNames     int    `split_words:"true" default:"10"`
+
+	DomainsFile string `split_words:"true"`
+
+	AcmeDirectoryURL string `split_words:"true"`
+	AcmeEmail        string `split_words:"true"`
+	AcmeCacheDir     string `split_words:"true" default:"acme"`
+	AcmeCaFile       string `split_words:"true"`
+
+	AcmeDNSProvider      string        `split_words:"true"`
+	AcmeRenewBefore      time.Duration `split_words:"true" default:"720h"`
+	AcmePropagationDelay time.Duration `split_words:"true" default:"30s"`
+
+	Rfc2136Nameserver    string            `split_words:"true"`
+	Rfc2136Zone          string            `split_words:"true"`
+	Rfc2136Zones         map[string]string `split_words:"true"`
+	Rfc2136TTL           time.Duration     `split_words:"true" default:"60s"`
+	Rfc2136TsigKey       string            `split_words:"true"`
+	Rfc2136TsigSecret    string            `split_words:"true"`
+	Rfc2136TsigAlgorithm string            `split_words:"true" default:"hmac-sha256"`
+
+	TCPPortRange  string `split_words:"true"`
+	TCPListenAddr string `split_words:"true" default:"0.0.0.0"`
+	TCPMaxPorts   int    `split_words:"true" default:"5"`
+
+	SessionTakeover bool `split_words:"true"`
+	DirectTCPIP     bool `split_words:"true"`
+
+	FingerprintScheme string `default:"md5" split_words:"true"`
+	LegacySubdomains  bool   `split_words:"true"`
 
 	LogLevel string `default:"info" split_words:"true"`
 
-	Debug       bool
-	WebHideInfo bool
+	Debug            bool
+	WebHideInfo      bool
+	WebMaxBufferSize int `split_words:"true" default:"65536"`
+
+	WebMaxIdleConns    int           `split_words:"true" default:"8"`
+	WebIdleConnTimeout time.Duration `split_words:"true" default:"30s"`
+	WebUpstreamTimeout time.Duration `split_words:"true" default:"60s"`
 }
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"crypto/tls"
+	"r-ssh/certs"
 	"r-ssh/common"
 	"r-ssh/ssh"
+	"r-ssh/ssh/audit"
 	"r-ssh/ssh/auth"
 	"r-ssh/web"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 func main() {
@@ -24,29 +28,156 @@ func main() {
 	logrus.SetLevel(logLevel)
 	logrus.SetFormatter(&logrus.TextFormatter{})
 
-	var authProvider auth.Provider
-	if len(cfg.PublicKeyWhitelist) == 0 {
-		authProvider = auth.DefaultAuthProvider
-	} else {
-		authProvider = auth.NewWhitelistAuthProvider(cfg.PublicKeyWhitelist)
+	fingerprintScheme, err := common.ParseFingerprintScheme(cfg.FingerprintScheme)
+	if err != nil {
+		logrus.WithError(err).Fatal("parse fingerprint scheme failed")
 	}
 
-	sshServer, err := ssh.NewServer(cfg.SSHEndpoint, cfg.Host, cfg.HostKey, authProvider)
+	serverOptions := ssh.ServerOptions{
+		FingerprintScheme: fingerprintScheme,
+		LegacySubdomains:  cfg.LegacySubdomains,
+		MaxAuthTries:      cfg.MaxAuthTries,
+		SessionTakeover:   cfg.SessionTakeover,
+		DirectTCPIP:       cfg.DirectTCPIP,
+	}
+	if cfg.AuditLogFile != "" {
+		serverOptions.AuditLog, err = audit.Open(cfg.AuditLogFile)
+		if err != nil {
+			logrus.WithError(err).Fatal("open audit log failed")
+		}
+	}
+	if cfg.NamesFile != "" {
+		serverOptions.NameRegistry, err = ssh.NewNameRegistry(cfg.NamesFile, cfg.MaxNames)
+		if err != nil {
+			logrus.WithError(err).Fatal("load names failed")
+		}
+	}
+	if cfg.DomainsFile != "" {
+		serverOptions.DomainRegistry, err = ssh.NewDomainRegistry(cfg.DomainsFile)
+		if err != nil {
+			logrus.WithError(err).Fatal("load domains failed")
+		}
+	}
+	if cfg.TCPPortRange != "" {
+		minPort, maxPort, err := common.ParsePortRange(cfg.TCPPortRange)
+		if err != nil {
+			logrus.WithError(err).Fatal("parse tcp port range failed")
+		}
+		serverOptions.TCPPorts = ssh.NewPortAllocator(minPort, maxPort)
+		serverOptions.TCPListenAddr = cfg.TCPListenAddr
+		serverOptions.TCPMaxPorts = cfg.TCPMaxPorts
+	}
+	if cfg.BanMaxFailures > 0 {
+		serverOptions.BanList = ssh.NewBanList(cfg.BanMaxFailures, cfg.BanWindow, cfg.BanDuration)
+	}
+
+	if cfg.RevokedKeysFile != "" {
+		serverOptions.RevocationList, err = auth.NewRevocationList(cfg.RevokedKeysFile)
+		if err != nil {
+			logrus.WithError(err).Fatal("load revoked keys failed")
+		}
+	}
+
+	policies, err := loadPolicies(&cfg)
+	if err != nil {
+		logrus.WithError(err).Fatal("auth provider initialization failed")
+	}
+	serverOptions.HostPolicies, err = buildHostPolicies(&cfg, policies)
+	if err != nil {
+		logrus.WithError(err).Fatal("auth provider initialization failed")
+	}
+	serverOptions.ExtraHosts = cfg.Host[1:]
+	if cfg.HtpasswdFile != "" {
+		serverOptions.PasswordProvider, err = buildPasswordProvider(&cfg, policies)
+		if err != nil {
+			logrus.WithError(err).Fatal("password provider initialization failed")
+		}
+	}
+
+	authProvider, err := buildAuthProvider(&cfg, policies, serverOptions.RevocationList)
+	if err != nil {
+		logrus.WithError(err).Fatal("auth provider initialization failed")
+	}
+
+	sshServer, err := ssh.NewServer(cfg.SSHEndpoint, cfg.Host[0], cfg.HostKey, authProvider, serverOptions)
 	if err != nil {
 		logrus.WithError(err).Fatalln("ssh server initialization failed")
 	}
 
+	if serverOptions.RevocationList != nil {
+		serverOptions.RevocationList.Watch(common.DefaultWatchInterval, sshServer.DisconnectRevoked)
+	}
+
 	go func() {
 		if err = sshServer.Listen(); err != nil {
 			logrus.WithError(err).Fatalln("ssh server listen failed")
 		}
 	}()
 
-	webServer := web.NewServer(sshServer, cfg.Host, cfg.WebHideInfo, cfg.SslRedirect)
+	var wildcardManagers map[string]*certs.WildcardManager
+	if cfg.AcmeDirectoryURL != "" && cfg.AcmeDNSProvider != "" {
+		wildcardManagers, err = buildWildcardManagers(&cfg)
+		if err != nil {
+			logrus.WithError(err).Fatal("wildcard certificate initialization failed")
+		}
+	}
+	if cfg.AcmeDirectoryURL != "" && serverOptions.DomainRegistry == nil && wildcardManagers == nil {
+		logrus.Fatal("acme requires custom domains or dns provider")
+	}
+
+	var certManager *autocert.Manager
+	if cfg.AcmeDirectoryURL != "" && serverOptions.DomainRegistry != nil {
+		certManager, err = web.NewCertManager(cfg.AcmeDirectoryURL, cfg.AcmeEmail, cfg.AcmeCacheDir, cfg.AcmeCaFile, serverOptions.DomainRegistry)
+		if err != nil {
+			logrus.WithError(err).Fatal("acme initialization failed")
+		}
+	}
+
+	certificates := certs.NewCertificates()
+	webServer := web.NewServer(sshServer, web.ServerOptions{
+		HideInfo:        cfg.WebHideInfo,
+		SslRedirect:     cfg.SslRedirect,
+		AdminToken:      cfg.AdminToken,
+		MaxBufferSize:   cfg.WebMaxBufferSize,
+		MaxIdleConns:    cfg.WebMaxIdleConns,
+		IdleConnTimeout: cfg.WebIdleConnTimeout,
+		UpstreamTimeout: cfg.WebUpstreamTimeout,
+		CertManager:     certManager,
+		Certificates:    certificates,
+	})
+
+	tlsEnabled := false
+	if len(cfg.CertFile) != 0 {
+		files, err := certs.NewFileCertificates(cfg.CertFile, cfg.KeyFile, certificates)
+		if err != nil {
+			logrus.WithError(err).Fatal("load certificates failed")
+		}
+		files.Watch(common.DefaultWatchInterval)
+		reloadOnHangup(files)
+		tlsEnabled = true
+	}
+	for _, host := range cfg.Host {
+		wildcardManager, ok := wildcardManagers[host]
+		if !ok {
+			continue
+		}
+		cert, err := wildcardManager.Certificate()
+		if err != nil {
+			logrus.WithError(err).WithField("host", host).Warnln("load wildcard certificate failed")
+		}
+		if cert != nil {
+			setWildcardCertificate(certificates, host, cert)
+		}
+		host := host
+		wildcardManager.Run(certs.DefaultRenewInterval, func(cert *tls.Certificate) {
+			setWildcardCertificate(certificates, host, cert)
+		})
+		tlsEnabled = true
+	}
 
-	if cfg.CertFile != "" && cfg.KeyFile != "" {
+	if tlsEnabled {
 		go func() {
-			if err = webServer.ListenTLS(cfg.SslWebEndpoint, cfg.CertFile, cfg.KeyFile); err != nil {
+			if err = webServer.ListenTLS(cfg.SslWebEndpoint); err != nil {
 				logrus.WithError(err).Fatalln("ssl web server listen failed")
 			}
 		}()
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type EventType string
+
+const (
+	// EventConnect is tcp connection accepted by ssh listener
+	EventConnect EventType = "connect"
+	// EventAuth is decision of auth provider about single key or password
+	EventAuth EventType = "auth"
+	// EventSession is completed handshake, it carries the identity the session is authenticated with
+	EventSession     EventType = "session"
+	EventTunnelOpen  EventType = "tunnel_open"
+	EventTunnelClose EventType = "tunnel_close"
+	EventDisconnect  EventType = "disconnect"
+	EventBan         EventType = "ban"
+	// EventDirect is direct-tcpip channel opened to a forward
+	EventDirect EventType = "direct_tcpip"
+)
+
+const (
+	ResultAllowed = "allowed"
+	ResultDenied  = "denied"
+)
+
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        EventType `json:"event"`
+	Session     string    `json:"session,omitempty"`
+	RemoteAddr  string    `json:"remote_addr,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Method      string    `json:"method,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Identity    string    `json:"identity,omitempty"`
+	Result      string    `json:"result,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	// Bind is "address:port" requested by the client
+	Bind string `json:"bind,omitempty"`
+	// URL is under the primary host, URLs lists it with urls under other hosts the forward is published under
+	URL    string   `json:"url,omitempty"`
+	URLs   []string `json:"urls,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+// Logger writes events as JSON lines, nil Logger discards events
+type Logger struct {
+	lock    sync.Mutex
+	writer  io.Writer
+	encoder *json.Encoder
+
+	logger *logrus.Entry
+}
+
+func (l *Logger) Log(event *Event) {
+	if l == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if err := l.encoder.Encode(event); err != nil {
+		l.logger.WithError(err).Errorln("write audit event failed")
+	}
+}
+
+func (l *Logger) Close() error {
+	if closer, ok := l.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func NewLogger(writer io.Writer) *Logger {
+	return &Logger{
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+		logger:  logrus.WithField("component", "audit"),
+	}
+}
+
+// Open appends events to file at path, the file is created if it doesn't exist
+func Open(path string) (*Logger, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogger(file), nil
+}
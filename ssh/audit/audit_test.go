@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLogger_Log(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := NewLogger(buffer)
+
+	logger.Log(&Event{Type: EventConnect, RemoteAddr: "192.0.2.1:1234"})
+	logger.Log(&Event{
+		Time:        time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC),
+		Type:        EventTunnelOpen,
+		Fingerprint: "fp",
+		URL:         "https://fp.example.com/",
+	})
+
+	lines := bytes.Split(bytes.TrimSpace(buffer.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("Log() wrote %d lines, want 2", len(lines))
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal(lines[0], &event); err != nil {
+		t.Fatalf("Unmarshal() error: %s", err)
+	}
+	if event["event"] != string(EventConnect) || event["time"] == "" {
+		t.Errorf("Log() event = %v", event)
+	}
+	if _, ok := event["url"]; ok {
+		t.Errorf("Log() must omit empty fields, got %v", event)
+	}
+
+	want := `{"time":"2020-07-01T00:00:00Z","event":"tunnel_open","fingerprint":"fp","url":"https://fp.example.com/"}`
+	if string(lines[1]) != want {
+		t.Errorf("Log() = %s, want %s", lines[1], want)
+	}
+}
+
+func TestLogger_LogNil(t *testing.T) {
+	var logger *Logger
+	logger.Log(&Event{Type: EventConnect})
+}
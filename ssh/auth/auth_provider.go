@@ -1,13 +1,43 @@
 package auth
 
+import (
+	"net"
+	"r-ssh/common"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+type Request struct {
+	User        string
+	RemoteAddr  net.Addr
+	PublicKey   ssh.PublicKey
+	Fingerprint string
+}
+
+// Grant describes accepted authentication
+type Grant struct {
+	// Identity is used instead of fingerprint to build subdomains
+	Identity string
+	// Policy restricts forwards of the session, nil means unrestricted
+	Policy *Policy
+
+	DisplayName string
+	Metadata    map[string]string
+}
+
+func NewGrant(req *Request) *Grant {
+	return &Grant{Identity: common.KeyIdentity(req.Fingerprint)}
+}
+
 type Provider interface {
-	Auth(string) bool
+	Auth(req *Request) (*Grant, error)
 }
 
 type defaultProvider struct{}
 
-func (*defaultProvider) Auth(string) bool {
-	return true
+func (*defaultProvider) Auth(req *Request) (*Grant, error) {
+	return NewGrant(req), nil
 }
 
 var DefaultAuthProvider = &defaultProvider{}
@@ -16,15 +46,38 @@ type WhitelistProvider struct {
 	whitelist map[string]struct{}
 }
 
-func (w *WhitelistProvider) Auth(fingerprint string) bool {
-	_, ok := w.whitelist[fingerprint]
-	return ok
+func (w *WhitelistProvider) allowed(req *Request) bool {
+	if _, ok := w.whitelist[req.Fingerprint]; ok {
+		return true
+	}
+	if req.PublicKey == nil {
+		return false
+	}
+
+	for _, fingerprint := range common.KeyFingerprints(req.PublicKey) {
+		if _, ok := w.whitelist[fingerprint]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *WhitelistProvider) Auth(req *Request) (*Grant, error) {
+	if !w.allowed(req) {
+		return nil, common.ErrAuthNotAllowed
+	}
+	return NewGrant(req), nil
 }
 
 func NewWhitelistAuthProvider(publicKeyWhitelist []string) *WhitelistProvider {
 	whitelist := make(map[string]struct{})
 	for _, s := range publicKeyWhitelist {
-		whitelist[s] = struct{}{}
+		fingerprint, err := common.NormalizeFingerprint(s)
+		if err != nil {
+			logrus.WithError(err).WithField("fingerprint", s).Warnln("unknown whitelist fingerprint format")
+			fingerprint = s
+		}
+		whitelist[fingerprint] = struct{}{}
 	}
 	return &WhitelistProvider{whitelist: whitelist}
 }
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"net"
 	"r-ssh/common"
 	"testing"
 
@@ -20,9 +21,18 @@ func parseKey(key string) ssh.PublicKey {
 	return publicKey
 }
 
+func newRequest(key ssh.PublicKey) *Request {
+	return &Request{
+		User:        "test",
+		RemoteAddr:  &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22},
+		PublicKey:   key,
+		Fingerprint: common.GetFingerprint(key),
+	}
+}
+
 func TestDefaultAuthProvider_Auth(t *testing.T) {
 	for _, key := range sshKeys {
-		if !DefaultAuthProvider.Auth(common.GetFingerprint(key)) {
+		if !allowed(DefaultAuthProvider.Auth(newRequest(key))) {
 			t.Error("Auth() must always return true")
 		}
 	}
@@ -32,13 +42,31 @@ func TestWhitelistAuthProvider_Auth(t *testing.T) {
 	allowedFingerprint := common.GetFingerprint(sshKey1)
 	authProvider := NewWhitelistAuthProvider([]string{allowedFingerprint})
 
-	if !authProvider.Auth(common.GetFingerprint(sshKey1)) {
+	if !allowed(authProvider.Auth(newRequest(sshKey1))) {
 		t.Errorf("Auth() must return true for - %s", allowedFingerprint)
 		return
 	}
 
-	if authProvider.Auth(common.GetFingerprint(sshKey2)) {
+	if allowed(authProvider.Auth(newRequest(sshKey2))) {
 		t.Errorf("Auth() must return false for - %s", ssh.FingerprintSHA256(sshKey2))
 		return
 	}
 }
+
+func TestWhitelistAuthProvider_AuthSHA256(t *testing.T) {
+	authProvider := NewWhitelistAuthProvider([]string{ssh.FingerprintSHA256(sshKey1)})
+
+	req := newRequest(sshKey1)
+	req.Fingerprint = common.FingerprintSHA256.Fingerprint(sshKey1)
+	if !allowed(authProvider.Auth(req)) {
+		t.Errorf("Auth() must return true for - %s", ssh.FingerprintSHA256(sshKey1))
+	}
+
+	if allowed(authProvider.Auth(newRequest(sshKey2))) {
+		t.Errorf("Auth() must return false for - %s", ssh.FingerprintSHA256(sshKey2))
+	}
+}
+
+func allowed(grant *Grant, err error) bool {
+	return err == nil && grant != nil
+}
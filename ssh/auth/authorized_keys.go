@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"r-ssh/common"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	optionFrom             = "from"
+	optionExpiryTime       = "expiry-time"
+	optionRestrict         = "restrict"
+	optionPortForwarding   = "port-forwarding"
+	optionNoPortForwarding = "no-port-forwarding"
+	optionPermitListen     = "permitlisten"
+
+	// r-ssh specific options, see Policy
+	optionMaxForwards     = "max-forwards"
+	optionPermitFlags     = "permit-flags"
+	optionPermitSubdomain = "permit-subdomain"
+	optionPermitDirect    = "permit-direct"
+	optionPermitHost      = "permit-host"
+	optionPermitPool      = "permit-pool"
+)
+
+var expiryTimeLayouts = []string{"20060102150405", "200601021504", "20060102"}
+
+type authorizedKey struct {
+	from           string
+	expiryTime     time.Time
+	forwardAllowed bool
+	policy         *Policy
+}
+
+func (k *authorizedKey) allowed(req *Request, now time.Time) bool {
+	if !k.forwardAllowed {
+		return false
+	}
+	if !k.expiryTime.IsZero() && now.After(k.expiryTime) {
+		return false
+	}
+	if k.from != "" && !common.MatchAddressList(common.AddrIP(req.RemoteAddr), k.from) {
+		return false
+	}
+	return true
+}
+
+type AuthorizedKeysProvider struct {
+	path string
+
+	keysLock sync.RWMutex
+	keys     map[string][]*authorizedKey
+
+	logger *logrus.Entry
+}
+
+func splitOption(option string) (string, string) {
+	parts := strings.SplitN(option, "=", 2)
+	if len(parts) == 1 {
+		return strings.ToLower(parts[0]), ""
+	}
+	return strings.ToLower(parts[0]), strings.Trim(parts[1], "\"")
+}
+
+func parseExpiryTime(value string) (time.Time, error) {
+	location := time.Local
+	if strings.HasSuffix(value, "Z") {
+		location = time.UTC
+		value = strings.TrimSuffix(value, "Z")
+	}
+
+	var err error
+	for _, layout := range expiryTimeLayouts {
+		var expiryTime time.Time
+		expiryTime, err = time.ParseInLocation(layout, value, location)
+		if err == nil {
+			return expiryTime, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func (k *authorizedKey) keyPolicy() *Policy {
+	if k.policy == nil {
+		k.policy = &Policy{}
+	}
+	return k.policy
+}
+
+// permitListenPattern converts OpenSSH "[host:]port" to "host:port" pattern
+func permitListenPattern(value string) string {
+	if !strings.Contains(value, ":") {
+		return common.DefaultForwardAddr + ":" + value
+	}
+	return value
+}
+
+func parseAuthorizedKeyOptions(options []string) (*authorizedKey, error) {
+	key := &authorizedKey{forwardAllowed: true}
+	for _, option := range options {
+		name, value := splitOption(option)
+		switch name {
+		case optionPermitListen:
+			key.keyPolicy().AllowedTargets = append(key.keyPolicy().AllowedTargets, permitListenPattern(value))
+		case optionMaxForwards:
+			maxForwards, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, err
+			}
+			key.keyPolicy().MaxForwards = maxForwards
+		case optionPermitFlags:
+			flags := value
+			key.keyPolicy().AllowedFlags = &flags
+		case optionPermitSubdomain:
+			key.keyPolicy().AllowedSubdomains = append(key.keyPolicy().AllowedSubdomains, value)
+		case optionPermitDirect:
+			key.keyPolicy().AllowedDirect = append(key.keyPolicy().AllowedDirect, value)
+		case optionPermitHost:
+			key.keyPolicy().AllowedHosts = append(key.keyPolicy().AllowedHosts, value)
+		case optionPermitPool:
+			key.keyPolicy().AllowedPools = append(key.keyPolicy().AllowedPools, value)
+		case optionFrom:
+			key.from = value
+		case optionExpiryTime:
+			expiryTime, err := parseExpiryTime(value)
+			if err != nil {
+				return nil, err
+			}
+			key.expiryTime = expiryTime
+		case optionRestrict, optionNoPortForwarding:
+			key.forwardAllowed = false
+		}
+	}
+
+	// "port-forwarding" re-enables forwarding after "restrict" regardless of order, like OpenSSH does
+	for _, option := range options {
+		if name, _ := splitOption(option); name == optionPortForwarding {
+			key.forwardAllowed = true
+		}
+	}
+	return key, nil
+}
+
+// parseAuthorizedKeys skips malformed lines like OpenSSH does, so one bad entry doesn't lock out every key
+func parseAuthorizedKeys(data []byte, logger *logrus.Entry) map[string][]*authorizedKey {
+	keys := make(map[string][]*authorizedKey)
+	for n, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		publicKey, _, options, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			logger.WithError(err).WithField("line", n+1).Warnln("skip authorized key")
+			continue
+		}
+
+		key, err := parseAuthorizedKeyOptions(options)
+		if err != nil {
+			logger.WithError(err).WithField("line", n+1).Warnln("skip authorized key")
+			continue
+		}
+
+		rawKey := string(publicKey.Marshal())
+		keys[rawKey] = append(keys[rawKey], key)
+	}
+	return keys
+}
+
+func (a *AuthorizedKeysProvider) Reload() error {
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+
+	keys := parseAuthorizedKeys(data, a.logger)
+
+	a.keysLock.Lock()
+	a.keys = keys
+	a.keysLock.Unlock()
+
+	a.logger.WithField("keys", len(keys)).Info("authorized keys loaded")
+	return nil
+}
+
+// Watch reloads the file on every change. Unreadable file is logged and the previous keys stay active.
+func (a *AuthorizedKeysProvider) Watch(interval time.Duration) (stop func()) {
+	return common.WatchFile(a.path, interval, func() {
+		if err := a.Reload(); err != nil {
+			a.logger.WithError(err).Warnln("reload authorized keys failed")
+		}
+	})
+}
+
+func (a *AuthorizedKeysProvider) Auth(req *Request) (*Grant, error) {
+	if req.PublicKey == nil {
+		return nil, common.ErrAuthNotAllowed
+	}
+
+	a.keysLock.RLock()
+	keys := a.keys[string(req.PublicKey.Marshal())]
+	a.keysLock.RUnlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		if key.allowed(req, now) {
+			grant := NewGrant(req)
+			grant.Policy = key.policy
+			return grant, nil
+		}
+	}
+	return nil, common.ErrAuthNotAllowed
+}
+
+func NewAuthorizedKeysProvider(path string) (*AuthorizedKeysProvider, error) {
+	provider := &AuthorizedKeysProvider{
+		path:   path,
+		logger: logrus.WithField("component", "authorized-keys"),
+	}
+	if err := provider.Reload(); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func authorizedKeyLine(options string, key ssh.PublicKey) string {
+	line := string(ssh.MarshalAuthorizedKey(key))
+	if options != "" {
+		line = options + " " + line
+	}
+	return line
+}
+
+func writeAuthorizedKeys(t *testing.T, path string, lines ...string) {
+	content := ""
+	for _, line := range lines {
+		content += line
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthorizedKeysProvider_Auth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "authorized-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "authorized_keys")
+	writeAuthorizedKeys(t, path, "# comment\n", authorizedKeyLine(`from="10.0.0.0/8,!10.0.0.1"`, sshKey1))
+
+	provider, err := NewAuthorizedKeysProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newRequest(sshKey1)
+	req.RemoteAddr = &net.TCPAddr{IP: net.IPv4(10, 1, 2, 3)}
+	if !allowed(provider.Auth(req)) {
+		t.Error("Auth() must return true for address inside from=")
+	}
+
+	req.RemoteAddr = &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1)}
+	if allowed(provider.Auth(req)) {
+		t.Error("Auth() must return false for negated address")
+	}
+
+	if allowed(provider.Auth(newRequest(sshKey2))) {
+		t.Error("Auth() must return false for unknown key")
+	}
+
+	writeAuthorizedKeys(t, path, authorizedKeyLine("", sshKey2))
+	if err := provider.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !allowed(provider.Auth(newRequest(sshKey2))) {
+		t.Error("Auth() must return true for key added after reload")
+	}
+	if allowed(provider.Auth(req)) {
+		t.Error("Auth() must return false for key removed after reload")
+	}
+
+	writeAuthorizedKeys(t, path, "ssh-ed25519 broken\n", authorizedKeyLine(`expiry-time="never"`, sshKey1), authorizedKeyLine("", sshKey2))
+	if err := provider.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !allowed(provider.Auth(newRequest(sshKey2))) {
+		t.Error("Auth() must return true for key after malformed lines")
+	}
+	if allowed(provider.Auth(newRequest(sshKey1))) {
+		t.Error("Auth() must return false for key with malformed options")
+	}
+}
+
+func Test_parseAuthorizedKeyOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		allowed bool
+	}{
+		{name: "No options", options: nil, allowed: true},
+		{name: "Restrict", options: []string{"restrict"}, allowed: false},
+		{name: "Restrict+PortForwarding", options: []string{"port-forwarding", "restrict"}, allowed: true},
+		{name: "NoPortForwarding", options: []string{"no-port-forwarding"}, allowed: false},
+		{name: "Expired", options: []string{`expiry-time="20000101"`}, allowed: false},
+		{name: "NotExpired", options: []string{`expiry-time="299901011200Z"`}, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseAuthorizedKeyOptions(tt.options)
+			if err != nil {
+				t.Fatalf("parseAuthorizedKeyOptions() error: %s", err)
+			}
+			if got := key.allowed(newRequest(sshKey1), time.Now()); got != tt.allowed {
+				t.Errorf("allowed() = %v, want %v", got, tt.allowed)
+			}
+		})
+	}
+}
+
+func Test_parseAuthorizedKeyOptionsPolicy(t *testing.T) {
+	key, err := parseAuthorizedKeyOptions([]string{`permitlisten="8080"`, `permitlisten="*.example.com:443"`, `max-forwards="3"`, `permit-flags="o"`, `permit-direct="db-*"`, `permit-host="*.internal"`})
+	if err != nil {
+		t.Fatalf("parseAuthorizedKeyOptions() error: %s", err)
+	}
+
+	if len(key.policy.AllowedTargets) != 2 || key.policy.AllowedTargets[0] != "localhost:8080" {
+		t.Errorf("AllowedTargets = %v", key.policy.AllowedTargets)
+	}
+	if key.policy.MaxForwards != 3 {
+		t.Errorf("MaxForwards = %d, want 3", key.policy.MaxForwards)
+	}
+	if key.policy.AllowedFlags == nil || *key.policy.AllowedFlags != "o" {
+		t.Errorf("AllowedFlags = %v, want o", key.policy.AllowedFlags)
+	}
+	if len(key.policy.AllowedDirect) != 1 || key.policy.AllowedDirect[0] != "db-*" {
+		t.Errorf("AllowedDirect = %v, want [db-*]", key.policy.AllowedDirect)
+	}
+	if len(key.policy.AllowedHosts) != 1 || key.policy.AllowedHosts[0] != "*.internal" {
+		t.Errorf("AllowedHosts = %v, want [*.internal]", key.policy.AllowedHosts)
+	}
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"r-ssh/common"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	sourceAddressOption = "source-address"
+	forceCommandOption  = "force-command"
+
+	permitPortForwardingExtension = "permit-port-forwarding"
+)
+
+// CertificateProvider accepts OpenSSH user certificates signed by one of the trusted authorities.
+// Plain public keys are passed to fallback provider. Revoked certificates are rejected by the server's
+// RevocationList before any provider runs.
+type CertificateProvider struct {
+	authorities map[string]struct{}
+	checker     *ssh.CertChecker
+	fallback    Provider
+}
+
+func (c *CertificateProvider) isUserAuthority(key ssh.PublicKey) bool {
+	_, ok := c.authorities[string(key.Marshal())]
+	return ok
+}
+
+// certificatePrincipal returns requested user if it is listed in the certificate, otherwise the first principal
+func certificatePrincipal(cert *ssh.Certificate, user string) (string, error) {
+	if len(cert.ValidPrincipals) == 0 {
+		return "", common.ErrNoCertificatePrincipals
+	}
+
+	for _, principal := range cert.ValidPrincipals {
+		if principal == user {
+			return principal, nil
+		}
+	}
+	return cert.ValidPrincipals[0], nil
+}
+
+func (c *CertificateProvider) Auth(req *Request) (*Grant, error) {
+	cert, ok := req.PublicKey.(*ssh.Certificate)
+	if !ok {
+		if c.fallback == nil {
+			return nil, common.ErrAuthNotAllowed
+		}
+		return c.fallback.Auth(req)
+	}
+
+	if cert.CertType != ssh.UserCert || !c.isUserAuthority(cert.SignatureKey) {
+		return nil, common.ErrUnknownCertificateAuthority
+	}
+
+	principal, err := certificatePrincipal(cert, req.User)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.checker.CheckCert(principal, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	// CertChecker leaves source-address to ssh.ServerConn which never sees it because of custom permissions
+	if sourceAddress, ok := cert.CriticalOptions[sourceAddressOption]; ok {
+		if !common.MatchAddressList(common.AddrIP(req.RemoteAddr), sourceAddress) {
+			return nil, common.ErrSourceAddressNotAllowed
+		}
+	}
+
+	if _, ok := cert.Extensions[permitPortForwardingExtension]; !ok {
+		return nil, common.ErrPortForwardingNotPermitted
+	}
+
+	identity, err := common.SanitizeIdentity(common.IdentityCertificate, principal)
+	if err != nil {
+		return nil, err
+	}
+	return &Grant{Identity: identity}, nil
+}
+
+func ParseCertificateAuthorities(data []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func LoadCertificateAuthorities(path string) ([]ssh.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCertificateAuthorities(data)
+}
+
+func NewCertificateProvider(authorities []ssh.PublicKey, fallback Provider) *CertificateProvider {
+	provider := &CertificateProvider{
+		authorities: make(map[string]struct{}),
+		fallback:    fallback,
+	}
+	for _, authority := range authorities {
+		provider.authorities[string(authority.Marshal())] = struct{}{}
+	}
+
+	provider.checker = &ssh.CertChecker{
+		// force-command is harmless because sessions never execute commands
+		SupportedCriticalOptions: []string{sourceAddressOption, forceCommandOption},
+		IsUserAuthority:          provider.isUserAuthority,
+	}
+	return provider
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func newCertificate(t *testing.T, authority ssh.Signer, principals []string, criticalOptions map[string]string) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:             sshKey1,
+		CertType:        ssh.UserCert,
+		KeyId:           "test",
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: criticalOptions,
+			Extensions:      map[string]string{permitPortForwardingExtension: ""},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, authority); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCertificateProvider_Auth(t *testing.T) {
+	authority := newSigner(t)
+	provider := NewCertificateProvider([]ssh.PublicKey{authority.PublicKey()}, nil)
+
+	req := newRequest(newCertificate(t, authority, []string{"alice", "test"}, nil))
+	grant, err := provider.Auth(req)
+	if err != nil {
+		t.Fatalf("Auth() error: %s", err)
+	}
+	if grant.Identity != "cert:test" {
+		t.Errorf("Auth() identity = %s, want principal matching user", grant.Identity)
+	}
+
+	req.User = "unknown"
+	grant, err = provider.Auth(req)
+	if err != nil {
+		t.Fatalf("Auth() error: %s", err)
+	}
+	if grant.Identity != "cert:alice" {
+		t.Errorf("Auth() identity = %s, want first principal", grant.Identity)
+	}
+
+	if allowed(provider.Auth(newRequest(newCertificate(t, authority, []string{"Alice.B"}, nil)))) {
+		t.Error("Auth() must return false for principal which isn't usable in subdomain")
+	}
+
+	if allowed(provider.Auth(newRequest(newCertificate(t, newSigner(t), []string{"test"}, nil)))) {
+		t.Error("Auth() must return false for unknown authority")
+	}
+
+	if allowed(provider.Auth(newRequest(sshKey1))) {
+		t.Error("Auth() must return false for plain key without fallback")
+	}
+
+	req = newRequest(newCertificate(t, authority, []string{"test"}, map[string]string{sourceAddressOption: "10.0.0.0/8"}))
+	if allowed(provider.Auth(req)) {
+		t.Error("Auth() must return false for address outside source-address")
+	}
+	req.RemoteAddr = &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1)}
+	if !allowed(provider.Auth(req)) {
+		t.Error("Auth() must return true for address inside source-address")
+	}
+
+	if allowed(provider.Auth(newRequest(newCertificate(t, authority, []string{"test"}, map[string]string{"unknown": ""})))) {
+		t.Error("Auth() must return false for unsupported critical option")
+	}
+}
+
+func TestCertificateProvider_AuthFallback(t *testing.T) {
+	provider := NewCertificateProvider(nil, NewWhitelistAuthProvider([]string{ssh.FingerprintSHA256(sshKey1)}))
+
+	if !allowed(provider.Auth(newRequest(sshKey1))) {
+		t.Error("Auth() must pass plain keys to fallback")
+	}
+	if allowed(provider.Auth(newRequest(sshKey2))) {
+		t.Error("Auth() must return false when fallback rejects")
+	}
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"fmt"
+	"r-ssh/common"
+	"strings"
+	"unicode"
+)
+
+const (
+	chainAny    = "any"
+	chainAll    = "all"
+	chainNot    = "not"
+	chainTiered = "tiered"
+)
+
+type chainParser struct {
+	tokens    []string
+	providers map[string]Provider
+	policies  map[string]*Policy
+}
+
+func tokenizeChain(expr string) []string {
+	var tokens []string
+	current := strings.Builder{}
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range expr {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case strings.ContainsRune("(),:", r):
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func (c *chainParser) peek() string {
+	if len(c.tokens) == 0 {
+		return ""
+	}
+	return c.tokens[0]
+}
+
+func (c *chainParser) next() string {
+	token := c.peek()
+	if len(c.tokens) > 0 {
+		c.tokens = c.tokens[1:]
+	}
+	return token
+}
+
+func (c *chainParser) expect(token string) error {
+	if got := c.next(); got != token {
+		return fmt.Errorf("%w: expected %q, got %q", common.ErrInvalidAuthChain, token, got)
+	}
+	return nil
+}
+
+func (c *chainParser) parseTier() (Tier, error) {
+	if len(c.tokens) > 1 && c.tokens[1] == ":" {
+		name := c.next()
+		c.next()
+
+		policy, ok := c.policies[name]
+		if !ok {
+			return Tier{}, fmt.Errorf("%w: unknown policy %q", common.ErrInvalidAuthChain, name)
+		}
+
+		provider, err := c.parseExpr()
+		return Tier{Provider: provider, Policy: policy}, err
+	}
+
+	provider, err := c.parseExpr()
+	return Tier{Provider: provider}, err
+}
+
+func (c *chainParser) parseArgs() ([]Tier, error) {
+	if err := c.expect("("); err != nil {
+		return nil, err
+	}
+
+	var tiers []Tier
+	for {
+		tier, err := c.parseTier()
+		if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, tier)
+
+		switch c.next() {
+		case ",":
+			continue
+		case ")":
+			return tiers, nil
+		default:
+			return nil, fmt.Errorf("%w: unterminated arguments", common.ErrInvalidAuthChain)
+		}
+	}
+}
+
+func tierProviders(tiers []Tier) ([]Provider, error) {
+	providers := make([]Provider, 0, len(tiers))
+	for _, tier := range tiers {
+		if tier.Policy != nil {
+			return nil, fmt.Errorf("%w: policies are allowed only in %s()", common.ErrInvalidAuthChain, chainTiered)
+		}
+		providers = append(providers, tier.Provider)
+	}
+	return providers, nil
+}
+
+func (c *chainParser) parseExpr() (Provider, error) {
+	name := c.next()
+	switch name {
+	case "", "(", ")", ",", ":":
+		return nil, fmt.Errorf("%w: unexpected %q", common.ErrInvalidAuthChain, name)
+	}
+
+	if c.peek() != "(" {
+		provider, ok := c.providers[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown provider %q", common.ErrInvalidAuthChain, name)
+		}
+		return provider, nil
+	}
+
+	tiers, err := c.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+	if name == chainTiered {
+		return Tiered(tiers...), nil
+	}
+
+	providers, err := tierProviders(tiers)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case chainAny:
+		return AnyOf(providers...), nil
+	case chainAll:
+		return AllOf(providers...), nil
+	case chainNot:
+		if len(providers) != 1 {
+			return nil, fmt.Errorf("%w: %s() takes exactly one argument", common.ErrInvalidAuthChain, chainNot)
+		}
+		return Not(providers[0]), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown combinator %q", common.ErrInvalidAuthChain, name)
+	}
+}
+
+// ParseChain builds provider from expression like "all(not(revoked), tiered(any(whitelist, certificate), anonymous: default))".
+// Leaves are names of providers, "name: provider" inside tiered() applies named policy to the tier.
+func ParseChain(expr string, providers map[string]Provider, policies map[string]*Policy) (Provider, error) {
+	parser := &chainParser{
+		tokens:    tokenizeChain(expr),
+		providers: providers,
+		policies:  policies,
+	}
+
+	provider, err := parser.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if len(parser.tokens) != 0 {
+		return nil, fmt.Errorf("%w: unexpected %q", common.ErrInvalidAuthChain, parser.peek())
+	}
+	return provider, nil
+}
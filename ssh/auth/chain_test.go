@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"r-ssh/common"
+	"testing"
+)
+
+func TestParseChain(t *testing.T) {
+	providers := map[string]Provider{
+		"default":   DefaultAuthProvider,
+		"whitelist": NewWhitelistAuthProvider([]string{common.GetFingerprint(sshKey1)}),
+	}
+	policies := map[string]*Policy{
+		"anonymous": {MaxForwards: 1},
+	}
+
+	tests := []struct {
+		expr  string
+		want1 bool
+		want2 bool
+	}{
+		{expr: "default", want1: true, want2: true},
+		{expr: "whitelist", want1: true, want2: false},
+		{expr: "not(whitelist)", want1: false, want2: true},
+		{expr: "any(not(default), whitelist)", want1: true, want2: false},
+		{expr: "all(default, not( whitelist ))", want1: false, want2: true},
+		{expr: "tiered(whitelist, anonymous: default)", want1: true, want2: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			provider, err := ParseChain(tt.expr, providers, policies)
+			if err != nil {
+				t.Fatalf("ParseChain() error: %s", err)
+			}
+			if got := allowed(provider.Auth(newRequest(sshKey1))); got != tt.want1 {
+				t.Errorf("Auth(sshKey1) = %v, want %v", got, tt.want1)
+			}
+			if got := allowed(provider.Auth(newRequest(sshKey2))); got != tt.want2 {
+				t.Errorf("Auth(sshKey2) = %v, want %v", got, tt.want2)
+			}
+		})
+	}
+}
+
+func TestParseChain_Tiered(t *testing.T) {
+	providers := map[string]Provider{
+		"default":   DefaultAuthProvider,
+		"whitelist": NewWhitelistAuthProvider([]string{common.GetFingerprint(sshKey1)}),
+	}
+	policies := map[string]*Policy{
+		"anonymous": {MaxForwards: 1},
+	}
+
+	provider, err := ParseChain("tiered(whitelist, anonymous: default)", providers, policies)
+	if err != nil {
+		t.Fatalf("ParseChain() error: %s", err)
+	}
+
+	grant, err := provider.Auth(newRequest(sshKey2))
+	if err != nil {
+		t.Fatalf("Auth() error: %s", err)
+	}
+	if grant.Policy == nil || grant.Policy.MaxForwards != 1 {
+		t.Errorf("Auth() policy = %+v, want anonymous policy", grant.Policy)
+	}
+}
+
+func TestParseChain_Invalid(t *testing.T) {
+	providers := map[string]Provider{"default": DefaultAuthProvider}
+	policies := map[string]*Policy{"anonymous": {}}
+
+	for _, expr := range []string{
+		"",
+		"unknown",
+		"any(",
+		"any()",
+		"any(default",
+		"any(default default)",
+		"default)",
+		"not(default, default)",
+		"maybe(default)",
+		"any(anonymous: default)",
+		"tiered(missing: default)",
+	} {
+		if _, err := ParseChain(expr, providers, policies); !errors.Is(err, common.ErrInvalidAuthChain) {
+			t.Errorf("ParseChain(%q) error = %v, want %v", expr, err, common.ErrInvalidAuthChain)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"errors"
+	"r-ssh/common"
+)
+
+// mergeGrants combines grants of providers which all accepted the same request.
+// Identity other than fingerprint (e.g. certificate principal) wins, policies restrict each other.
+func mergeGrants(req *Request, a, b *Grant) *Grant {
+	merged := *a
+	keyIdentity := common.KeyIdentity(req.Fingerprint)
+	if merged.Identity == keyIdentity && b.Identity != keyIdentity {
+		merged.Identity = b.Identity
+	}
+	if merged.DisplayName == "" {
+		merged.DisplayName = b.DisplayName
+	}
+	if merged.Metadata == nil {
+		merged.Metadata = b.Metadata
+	}
+	merged.Policy = a.Policy.Merge(b.Policy)
+	return &merged
+}
+
+type anyOfProvider []Provider
+
+func (a anyOfProvider) Auth(req *Request) (*Grant, error) {
+	err := common.ErrAuthNotAllowed
+	for _, provider := range a {
+		var grant *Grant
+		grant, err = provider.Auth(req)
+		if err == nil {
+			return grant, nil
+		}
+	}
+	return nil, err
+}
+
+// AnyOf accepts request if any provider accepts it, grant of the first accepting provider is used
+func AnyOf(providers ...Provider) Provider {
+	return anyOfProvider(providers)
+}
+
+type allOfProvider []Provider
+
+func (a allOfProvider) Auth(req *Request) (*Grant, error) {
+	var result *Grant
+	for _, provider := range a {
+		grant, err := provider.Auth(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if result == nil {
+			result = grant
+		} else {
+			result = mergeGrants(req, result, grant)
+		}
+	}
+
+	if result == nil {
+		return nil, common.ErrAuthNotAllowed
+	}
+	return result, nil
+}
+
+// AllOf accepts request only if every provider accepts it, grants are merged
+func AllOf(providers ...Provider) Provider {
+	return allOfProvider(providers)
+}
+
+type notProvider struct {
+	provider Provider
+}
+
+func (n *notProvider) Auth(req *Request) (*Grant, error) {
+	_, err := n.provider.Auth(req)
+	switch {
+	case err == nil:
+		return nil, common.ErrAuthNotAllowed
+	case errors.Is(err, common.ErrAuthNotAllowed):
+		return NewGrant(req), nil
+	default:
+		// failures like callout timeouts must not turn into acceptance
+		return nil, err
+	}
+}
+
+// Not accepts request which provider explicitly rejects, other errors are returned
+func Not(provider Provider) Provider {
+	return &notProvider{provider: provider}
+}
+
+type Tier struct {
+	Provider Provider
+	// Policy applied to every session of the tier, nil keeps provider policy as is
+	Policy *Policy
+}
+
+type tieredProvider []Tier
+
+func (t tieredProvider) Auth(req *Request) (*Grant, error) {
+	err := common.ErrAuthNotAllowed
+	for _, tier := range t {
+		var grant *Grant
+		grant, err = tier.Provider.Auth(req)
+		if err != nil {
+			continue
+		}
+
+		restricted := *grant
+		restricted.Policy = grant.Policy.Merge(tier.Policy)
+		return &restricted, nil
+	}
+	return nil, err
+}
+
+// Tiered tries tiers in order and applies policy of the first accepting tier,
+// e.g. known keys with full rights followed by restricted anonymous tier
+func Tiered(tiers ...Tier) Provider {
+	return tieredProvider(tiers)
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"r-ssh/common"
+	"testing"
+)
+
+type providerFunc func(req *Request) (*Grant, error)
+
+func (f providerFunc) Auth(req *Request) (*Grant, error) {
+	return f(req)
+}
+
+func TestCombinators(t *testing.T) {
+	whitelist := NewWhitelistAuthProvider([]string{common.GetFingerprint(sshKey1)})
+	deny := Not(DefaultAuthProvider)
+	failing := providerFunc(func(req *Request) (*Grant, error) {
+		return nil, common.ErrUnexpectedAuthResponse
+	})
+
+	tests := []struct {
+		name     string
+		provider Provider
+		want1    bool
+		want2    bool
+	}{
+		{name: "any", provider: AnyOf(deny, whitelist), want1: true, want2: false},
+		{name: "any empty", provider: AnyOf(), want1: false, want2: false},
+		{name: "all", provider: AllOf(DefaultAuthProvider, whitelist), want1: true, want2: false},
+		{name: "all deny", provider: AllOf(whitelist, deny), want1: false, want2: false},
+		{name: "all empty", provider: AllOf(), want1: false, want2: false},
+		{name: "not", provider: Not(whitelist), want1: false, want2: true},
+		{name: "not failing", provider: Not(failing), want1: false, want2: false},
+		{name: "tiered", provider: Tiered(Tier{Provider: deny}, Tier{Provider: whitelist}), want1: true, want2: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allowed(tt.provider.Auth(newRequest(sshKey1))); got != tt.want1 {
+				t.Errorf("Auth(sshKey1) = %v, want %v", got, tt.want1)
+			}
+			if got := allowed(tt.provider.Auth(newRequest(sshKey2))); got != tt.want2 {
+				t.Errorf("Auth(sshKey2) = %v, want %v", got, tt.want2)
+			}
+		})
+	}
+}
+
+func TestAllOf_MergesGrants(t *testing.T) {
+	identity := providerFunc(func(req *Request) (*Grant, error) {
+		grant := NewGrant(req)
+		grant.Identity = "alice"
+		grant.Policy = &Policy{MaxForwards: 2}
+		return grant, nil
+	})
+	limited := WithPolicy(DefaultAuthProvider, &Policy{MaxForwards: 5, AllowedFlags: stringPtr("s")})
+
+	grant, err := AllOf(limited, identity).Auth(newRequest(sshKey1))
+	if err != nil {
+		t.Fatalf("Auth() error: %s", err)
+	}
+	if grant.Identity != "alice" {
+		t.Errorf("Auth() identity = %s, want alice", grant.Identity)
+	}
+	if grant.Policy.MaxForwards != 2 || grant.Policy.AllowedFlags == nil || *grant.Policy.AllowedFlags != "s" {
+		t.Errorf("Auth() policy = %+v, want merged policy", grant.Policy)
+	}
+}
+
+func TestAllOf_OverlappingTargets(t *testing.T) {
+	anyTarget := WithPolicy(DefaultAuthProvider, &Policy{AllowedTargets: []string{"*:*"}})
+	localTarget := WithPolicy(DefaultAuthProvider, &Policy{AllowedTargets: []string{"localhost:*"}})
+
+	grant, err := AllOf(anyTarget, localTarget).Auth(newRequest(sshKey1))
+	if err != nil {
+		t.Fatalf("Auth() error: %s", err)
+	}
+	if err = grant.Policy.CheckForward(common.BuildForwardInfo("f", "localhost", 8080), 0); err != nil {
+		t.Errorf("CheckForward() = %v, want nil", err)
+	}
+	if err = grant.Policy.CheckForward(common.BuildForwardInfo("f", "other", 8080), 0); err != common.ErrTargetNotAllowed {
+		t.Errorf("CheckForward() = %v, want %v", err, common.ErrTargetNotAllowed)
+	}
+}
+
+func TestTiered_Policy(t *testing.T) {
+	whitelist := NewWhitelistAuthProvider([]string{common.GetFingerprint(sshKey1)})
+	anonymous := &Policy{MaxForwards: 1}
+	provider := Tiered(Tier{Provider: whitelist}, Tier{Provider: DefaultAuthProvider, Policy: anonymous})
+
+	grant, err := provider.Auth(newRequest(sshKey1))
+	if err != nil {
+		t.Fatalf("Auth() error: %s", err)
+	}
+	if grant.Policy != nil {
+		t.Errorf("Auth() policy = %+v, want nil for known key", grant.Policy)
+	}
+
+	grant, err = provider.Auth(newRequest(sshKey2))
+	if err != nil {
+		t.Fatalf("Auth() error: %s", err)
+	}
+	if grant.Policy == nil || grant.Policy.MaxForwards != 1 {
+		t.Errorf("Auth() policy = %+v, want anonymous policy", grant.Policy)
+	}
+}
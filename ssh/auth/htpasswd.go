@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"r-ssh/common"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const htpasswdDelimiter = ":"
+
+// dummyHash is compared for unknown users so response time doesn't reveal which users exist
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+type PasswordProvider interface {
+	AuthPassword(req *Request, password []byte) (*Grant, error)
+}
+
+// HtpasswdProvider checks passwords against htpasswd file with bcrypt hashes ("htpasswd -B")
+type HtpasswdProvider struct {
+	path string
+
+	usersLock sync.RWMutex
+	users     map[string][]byte
+
+	logger *logrus.Entry
+}
+
+func parseHtpasswd(data []byte) (map[string][]byte, error) {
+	users := make(map[string][]byte)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		parts := strings.SplitN(string(line), htpasswdDelimiter, 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, common.ErrInvalidHtpasswdLine
+		}
+
+		hash := []byte(parts[1])
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, err
+		}
+		users[parts[0]] = hash
+	}
+	return users, nil
+}
+
+func (h *HtpasswdProvider) Reload() error {
+	data, err := ioutil.ReadFile(h.path)
+	if err != nil {
+		return err
+	}
+
+	users, err := parseHtpasswd(data)
+	if err != nil {
+		return err
+	}
+
+	h.usersLock.Lock()
+	h.users = users
+	h.usersLock.Unlock()
+
+	h.logger.WithField("users", len(users)).Info("htpasswd loaded")
+	return nil
+}
+
+func (h *HtpasswdProvider) Watch(interval time.Duration) (stop func()) {
+	return common.WatchFile(h.path, interval, func() {
+		if err := h.Reload(); err != nil {
+			h.logger.WithError(err).Warnln("reload htpasswd failed")
+		}
+	})
+}
+
+func (h *HtpasswdProvider) AuthPassword(req *Request, password []byte) (*Grant, error) {
+	h.usersLock.RLock()
+	hash, ok := h.users[req.User]
+	h.usersLock.RUnlock()
+
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, password)
+		return nil, common.ErrAuthNotAllowed
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, password); err != nil {
+		return nil, common.ErrAuthNotAllowed
+	}
+	identity, err := common.SanitizeIdentity(common.IdentityPassword, req.User)
+	if err != nil {
+		return nil, err
+	}
+	return &Grant{Identity: identity}, nil
+}
+
+type passwordPolicyProvider struct {
+	provider PasswordProvider
+	policy   *Policy
+}
+
+func (p *passwordPolicyProvider) AuthPassword(req *Request, password []byte) (*Grant, error) {
+	grant, err := p.provider.AuthPassword(req, password)
+	if err != nil {
+		return nil, err
+	}
+
+	restricted := *grant
+	restricted.Policy = grant.Policy.Merge(p.policy)
+	return &restricted, nil
+}
+
+// WithPasswordPolicy applies policy on top of policies returned by password provider, see WithPolicy
+func WithPasswordPolicy(provider PasswordProvider, policy *Policy) PasswordProvider {
+	if policy == nil {
+		return provider
+	}
+	return &passwordPolicyProvider{provider: provider, policy: policy}
+}
+
+func NewHtpasswdProvider(path string) (*HtpasswdProvider, error) {
+	provider := &HtpasswdProvider{
+		path:   path,
+		logger: logrus.WithField("component", "htpasswd"),
+	}
+	if err := provider.Reload(); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHtpasswdProvider_AuthPassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := parseHtpasswd([]byte("# comment\nalice:" + string(hash) + "\nAlice:" + string(hash) + "\n"))
+	if err != nil {
+		t.Fatalf("parseHtpasswd() error: %s", err)
+	}
+	provider := &HtpasswdProvider{users: users}
+
+	req := &Request{User: "alice"}
+	grant, err := provider.AuthPassword(req, []byte("secret"))
+	if err != nil {
+		t.Fatalf("AuthPassword() error: %s", err)
+	}
+	if grant.Identity != "pw:alice" {
+		t.Errorf("AuthPassword() identity = %s, want pw:alice", grant.Identity)
+	}
+
+	if allowed(provider.AuthPassword(&Request{User: "Alice"}, []byte("secret"))) {
+		t.Error("AuthPassword() must return false for user colliding with another one")
+	}
+
+	if allowed(provider.AuthPassword(req, []byte("wrong"))) {
+		t.Error("AuthPassword() must return false for wrong password")
+	}
+
+	req.User = "bob"
+	if allowed(provider.AuthPassword(req, []byte("secret"))) {
+		t.Error("AuthPassword() must return false for unknown user")
+	}
+}
+
+func Test_parseHtpasswd(t *testing.T) {
+	if _, err := parseHtpasswd([]byte("alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=")); err == nil {
+		t.Error("parseHtpasswd() must reject non bcrypt hashes")
+	}
+	if _, err := parseHtpasswd([]byte("alice")); err == nil {
+		t.Error("parseHtpasswd() must reject line without hash")
+	}
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"r-ssh/common"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+type callbackRequest struct {
+	Fingerprint string `json:"fingerprint"`
+	User        string `json:"user"`
+	RemoteAddr  string `json:"remote_addr"`
+}
+
+type callbackResponse struct {
+	Allow       bool              `json:"allow"`
+	DisplayName string            `json:"display_name,omitempty"`
+	Subdomain   string            `json:"subdomain,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Policy      *Policy           `json:"policy,omitempty"`
+}
+
+type cachedDecision struct {
+	grant   *Grant
+	expires time.Time
+}
+
+// HTTPProvider asks external service whether key is allowed.
+// The service receives JSON callbackRequest via POST and must answer with JSON callbackResponse.
+type HTTPProvider struct {
+	url     string
+	timeout time.Duration
+	ttl     time.Duration
+	client  *fasthttp.Client
+
+	cacheLock sync.Mutex
+	cache     map[callbackRequest]*cachedDecision
+}
+
+func (h *HTTPProvider) cached(key callbackRequest, now time.Time) (*cachedDecision, bool) {
+	h.cacheLock.Lock()
+	defer h.cacheLock.Unlock()
+
+	decision, ok := h.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(decision.expires) {
+		delete(h.cache, key)
+		return nil, false
+	}
+	return decision, true
+}
+
+func (h *HTTPProvider) store(key callbackRequest, grant *Grant, now time.Time) {
+	if h.ttl <= 0 {
+		return
+	}
+
+	h.cacheLock.Lock()
+	defer h.cacheLock.Unlock()
+
+	for k, decision := range h.cache {
+		if now.After(decision.expires) {
+			delete(h.cache, k)
+		}
+	}
+	h.cache[key] = &cachedDecision{grant: grant, expires: now.Add(h.ttl)}
+}
+
+func (h *HTTPProvider) call(key callbackRequest) (*callbackResponse, error) {
+	body, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(h.url)
+	req.Header.SetMethod(http.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.SetBody(body)
+
+	err = h.client.DoTimeout(req, resp, h.timeout)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, common.ErrUnexpectedAuthResponse
+	}
+
+	var result callbackResponse
+	err = json.Unmarshal(resp.Body(), &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (h *HTTPProvider) Auth(req *Request) (*Grant, error) {
+	key := callbackRequest{
+		Fingerprint: req.Fingerprint,
+		User:        req.User,
+	}
+	if ip := common.AddrIP(req.RemoteAddr); ip != nil {
+		key.RemoteAddr = ip.String()
+	}
+
+	now := time.Now()
+	if decision, ok := h.cached(key, now); ok {
+		if decision.grant == nil {
+			return nil, common.ErrAuthNotAllowed
+		}
+		return decision.grant, nil
+	}
+
+	// errors are not cached so a temporary outage doesn't lock users out for the whole ttl
+	resp, err := h.call(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.Allow {
+		h.store(key, nil, now)
+		return nil, common.ErrAuthNotAllowed
+	}
+
+	grant := NewGrant(req)
+	if resp.Subdomain != "" {
+		if grant.Identity, err = common.SanitizeIdentity(common.IdentityCallout, resp.Subdomain); err != nil {
+			return nil, err
+		}
+	}
+	grant.DisplayName = resp.DisplayName
+	grant.Metadata = resp.Metadata
+	grant.Policy = resp.Policy
+
+	h.store(key, grant, now)
+	return grant, nil
+}
+
+func NewHTTPProvider(url string, timeout, ttl time.Duration) *HTTPProvider {
+	return &HTTPProvider{
+		url:     url,
+		timeout: timeout,
+		ttl:     ttl,
+		client:  &fasthttp.Client{Name: common.ApplicationName},
+		cache:   make(map[callbackRequest]*cachedDecision),
+	}
+}
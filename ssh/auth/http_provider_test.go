@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPProvider_Auth(t *testing.T) {
+	allowedRequest := newRequest(sshKey1)
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		var req callbackRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		resp := callbackResponse{}
+		if req.Fingerprint == allowedRequest.Fingerprint && req.User == allowedRequest.User && req.RemoteAddr == "127.0.0.1" {
+			resp = callbackResponse{
+				Allow:       true,
+				DisplayName: "Test User",
+				Subdomain:   "test",
+				Metadata:    map[string]string{"team": "core"},
+				Policy:      &Policy{MaxForwards: 1},
+			}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	provider := NewHTTPProvider(server.URL, time.Second, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		grant, err := provider.Auth(allowedRequest)
+		if err != nil {
+			t.Fatalf("Auth() error: %s", err)
+		}
+		if grant.Identity != "http:test" || grant.DisplayName != "Test User" || grant.Metadata["team"] != "core" || grant.Policy.MaxForwards != 1 {
+			t.Errorf("Auth() grant = %+v", grant)
+		}
+	}
+
+	if allowed(provider.Auth(newRequest(sshKey2))) {
+		t.Error("Auth() must return false when service denies")
+	}
+	if allowed(provider.Auth(newRequest(sshKey2))) {
+		t.Error("Auth() must return false for cached denial")
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("service called %d times, want 2", got)
+	}
+}
+
+func TestHTTPProvider_AuthUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if allowed(NewHTTPProvider(server.URL, time.Second, time.Minute).Auth(newRequest(sshKey1))) {
+		t.Error("Auth() must return false when service fails")
+	}
+}
@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // KRL format references keys by sha1 fingerprint
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+	"r-ssh/common"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Source: https://cvsweb.openbsd.org/src/usr.bin/ssh/PROTOCOL.krl
+const krlMagic = "SSHKRL\n\x00"
+const krlFormatVersion = 1
+
+const (
+	krlSectionCertificates      = 1
+	krlSectionExplicitKey       = 2
+	krlSectionFingerprintSHA1   = 3
+	krlSectionSignature         = 4
+	krlSectionFingerprintSHA256 = 5
+)
+
+const (
+	krlCertSectionSerialList   = 0x20
+	krlCertSectionSerialRange  = 0x21
+	krlCertSectionSerialBitmap = 0x22
+	krlCertSectionKeyID        = 0x23
+)
+
+type serialRange struct {
+	min, max uint64
+}
+
+type serialBitmap struct {
+	offset uint64
+	bitmap *big.Int
+}
+
+type krlCertificates struct {
+	serials []uint64
+	ranges  []serialRange
+	bitmaps []serialBitmap
+	keyIDs  map[string]struct{}
+}
+
+func (c *krlCertificates) revoked(cert *ssh.Certificate) bool {
+	if _, ok := c.keyIDs[cert.KeyId]; ok {
+		return true
+	}
+
+	for _, serial := range c.serials {
+		if serial == cert.Serial {
+			return true
+		}
+	}
+	for _, r := range c.ranges {
+		if cert.Serial >= r.min && cert.Serial <= r.max {
+			return true
+		}
+	}
+	for _, b := range c.bitmaps {
+		if cert.Serial >= b.offset && cert.Serial-b.offset < uint64(b.bitmap.BitLen()) && b.bitmap.Bit(int(cert.Serial-b.offset)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// KRL is OpenSSH key revocation list, signatures of the list are not verified
+type KRL struct {
+	// certificates are keyed by marshaled CA key, empty key matches any CA
+	certificates map[string]*krlCertificates
+	keys         map[string]struct{}
+	sha1         map[string]struct{}
+	sha256       map[string]struct{}
+}
+
+func (k *KRL) keyRevoked(key ssh.PublicKey) bool {
+	blob := key.Marshal()
+	if _, ok := k.keys[string(blob)]; ok {
+		return true
+	}
+
+	sha1Sum := sha1.Sum(blob) //nolint:gosec
+	if _, ok := k.sha1[string(sha1Sum[:])]; ok {
+		return true
+	}
+
+	sha256Sum := sha256.Sum256(blob)
+	_, ok := k.sha256[string(sha256Sum[:])]
+	return ok
+}
+
+func (k *KRL) IsRevoked(key ssh.PublicKey) bool {
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return k.keyRevoked(key)
+	}
+
+	if k.keyRevoked(cert.Key) {
+		return true
+	}
+
+	for _, caKey := range []string{string(cert.SignatureKey.Marshal()), ""} {
+		if certificates, ok := k.certificates[caKey]; ok && certificates.revoked(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *KRL) addCertificates(caKey string, certificates *krlCertificates) {
+	existing, ok := k.certificates[caKey]
+	if !ok {
+		k.certificates[caKey] = certificates
+		return
+	}
+
+	existing.serials = append(existing.serials, certificates.serials...)
+	existing.ranges = append(existing.ranges, certificates.ranges...)
+	existing.bitmaps = append(existing.bitmaps, certificates.bitmaps...)
+	for keyID := range certificates.keyIDs {
+		existing.keyIDs[keyID] = struct{}{}
+	}
+}
+
+type krlReader struct {
+	data []byte
+	err  error
+}
+
+func (r *krlReader) empty() bool {
+	return r.err != nil || len(r.data) == 0
+}
+
+func (r *krlReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = common.ErrInvalidKRL
+		return nil
+	}
+
+	result := r.data[:n]
+	r.data = r.data[n:]
+	return result
+}
+
+func (r *krlReader) byte() byte {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *krlReader) uint32() uint32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *krlReader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *krlReader) string() []byte {
+	length := r.uint32()
+	if uint64(length) > uint64(len(r.data)) {
+		r.err = common.ErrInvalidKRL
+		return nil
+	}
+	return r.next(int(length))
+}
+
+func parseKRLCertificates(data []byte) (string, *krlCertificates, error) {
+	r := &krlReader{data: data}
+	caKey := r.string()
+	_ = r.string() // reserved
+
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	if len(caKey) > 0 {
+		key, err := ssh.ParsePublicKey(caKey)
+		if err != nil {
+			return "", nil, err
+		}
+		caKey = key.Marshal()
+	}
+
+	certificates := &krlCertificates{keyIDs: make(map[string]struct{})}
+	for !r.empty() {
+		sectionType := r.byte()
+		section := &krlReader{data: r.string()}
+
+		for !section.empty() {
+			switch sectionType {
+			case krlCertSectionSerialList:
+				certificates.serials = append(certificates.serials, section.uint64())
+			case krlCertSectionSerialRange:
+				certificates.ranges = append(certificates.ranges, serialRange{min: section.uint64(), max: section.uint64()})
+			case krlCertSectionSerialBitmap:
+				offset := section.uint64()
+				// mpint is big endian, revoked serials are never negative
+				bitmap := new(big.Int).SetBytes(section.string())
+				certificates.bitmaps = append(certificates.bitmaps, serialBitmap{offset: offset, bitmap: bitmap})
+			case krlCertSectionKeyID:
+				certificates.keyIDs[string(section.string())] = struct{}{}
+			default:
+				return "", nil, common.ErrInvalidKRL
+			}
+		}
+		if section.err != nil {
+			return "", nil, section.err
+		}
+	}
+	return string(caKey), certificates, r.err
+}
+
+func readKRLStrings(data []byte, target map[string]struct{}) error {
+	r := &krlReader{data: data}
+	for !r.empty() {
+		target[string(r.string())] = struct{}{}
+	}
+	return r.err
+}
+
+func IsKRL(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(krlMagic))
+}
+
+func ParseKRL(data []byte) (*KRL, error) {
+	if !IsKRL(data) {
+		return nil, common.ErrInvalidKRL
+	}
+
+	r := &krlReader{data: data[len(krlMagic):]}
+	if r.uint32() != krlFormatVersion {
+		return nil, common.ErrInvalidKRL
+	}
+	_ = r.uint64() // krl version
+	_ = r.uint64() // generated date
+	_ = r.uint64() // flags
+	_ = r.string() // reserved
+	_ = r.string() // comment
+
+	krl := &KRL{
+		certificates: make(map[string]*krlCertificates),
+		keys:         make(map[string]struct{}),
+		sha1:         make(map[string]struct{}),
+		sha256:       make(map[string]struct{}),
+	}
+	for !r.empty() {
+		sectionType := r.byte()
+		section := r.string()
+		if r.err != nil {
+			break
+		}
+
+		var err error
+		switch sectionType {
+		case krlSectionCertificates:
+			var caKey string
+			var certificates *krlCertificates
+			caKey, certificates, err = parseKRLCertificates(section)
+			if err == nil {
+				krl.addCertificates(caKey, certificates)
+			}
+		case krlSectionExplicitKey:
+			err = readKRLStrings(section, krl.keys)
+		case krlSectionFingerprintSHA1:
+			err = readKRLStrings(section, krl.sha1)
+		case krlSectionFingerprintSHA256:
+			err = readKRLStrings(section, krl.sha256)
+		case krlSectionSignature:
+			// signatures are the last sections and aren't verified
+			return krl, nil
+		default:
+			err = common.ErrInvalidKRL
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return krl, r.err
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"r-ssh/common"
+	"strconv"
+	"strings"
+)
+
+// Policy limits what an authenticated session is allowed to forward.
+// nil lists (and nil AllowedFlags) allow anything, empty lists allow nothing.
+type Policy struct {
+	// AllowedTargets are "host:port" patterns matched against the requested -R bind address, or socket path patterns
+	AllowedTargets []string `json:"allowed_targets,omitempty"`
+	// MaxForwards limits simultaneous forwards, 0 means unlimited
+	MaxForwards int `json:"max_forwards,omitempty"`
+	// AllowedFlags lists forward flags which may be used, e.g. "o" forbids "s"
+	AllowedFlags *string `json:"allowed_flags,omitempty"`
+	// AllowedSubdomains are patterns matched against custom (non default) hosts and reserved names
+	AllowedSubdomains []string `json:"allowed_subdomains,omitempty"`
+	// AllowedDirect are patterns matched against subdomains of other identities reachable with direct-tcpip
+	// channels, unlike other lists nil allows nothing
+	AllowedDirect []string `json:"allowed_direct,omitempty"`
+	// AllowedHosts are patterns matched against base domains the forwards are published under
+	AllowedHosts []string `json:"allowed_hosts,omitempty"`
+	// AllowedPools are patterns matched against names whose pools are shared with other identities, unlike
+	// other lists nil allows nothing
+	AllowedPools []string `json:"allowed_pools,omitempty"`
+
+	// all are policies combined by Merge, every one of them must allow the request. Patterns of different
+	// policies can't be intersected as strings, e.g. "*:*" and "localhost:*"
+	all []*Policy
+}
+
+// checkAll returns the first error of check run against every merged policy
+func (p *Policy) checkAll(check func(policy *Policy) error) error {
+	for _, policy := range p.all {
+		if err := check(policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, value string) bool {
+	if patterns == nil {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(value)); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckForward validates requested forward, forwards is the number of already active forwards of the session
+func (p *Policy) CheckForward(info *common.ForwardInfo, forwards int) error {
+	if p == nil {
+		return nil
+	}
+	if p.all != nil {
+		return p.checkAll(func(policy *Policy) error { return policy.CheckForward(info, forwards) })
+	}
+
+	if p.MaxForwards > 0 && forwards >= p.MaxForwards {
+		return common.ErrForwardLimitExceeded
+	}
+
+	target := info.Host + ":" + strconv.Itoa(int(info.Port))
+	if info.SocketPath != "" {
+		target = info.SocketPath
+	}
+	if !matchAny(p.AllowedTargets, target) {
+		return common.ErrTargetNotAllowed
+	}
+
+	if p.AllowedFlags != nil {
+		for _, flag := range info.Flags() {
+			if !strings.ContainsRune(*p.AllowedFlags, flag) {
+				return common.ErrFlagNotAllowed
+			}
+		}
+	}
+
+	if info.Name != "" && !matchAny(p.AllowedSubdomains, info.Name) {
+		return common.ErrSubdomainNotAllowed
+	}
+	if info.Host != common.DefaultForwardAddr && !matchAny(p.AllowedSubdomains, info.Host) {
+		return common.ErrSubdomainNotAllowed
+	}
+	return nil
+}
+
+// CheckDirect validates direct-tcpip channel to forward of subdomain owned by other identity, it must be allowed explicitly
+func (p *Policy) CheckDirect(subdomain string) error {
+	if p != nil && p.all != nil {
+		return p.checkAll(func(policy *Policy) error { return policy.CheckDirect(subdomain) })
+	}
+	if p == nil || p.AllowedDirect == nil || !matchAny(p.AllowedDirect, subdomain) {
+		return common.ErrDirectNotAllowed
+	}
+	return nil
+}
+
+// CheckHost validates publishing forwards under base domain host
+func (p *Policy) CheckHost(host string) error {
+	if p == nil {
+		return nil
+	}
+	if p.all != nil {
+		return p.checkAll(func(policy *Policy) error { return policy.CheckHost(host) })
+	}
+
+	if !matchAny(p.AllowedHosts, host) {
+		return common.ErrHostNotAllowed
+	}
+	return nil
+}
+
+// CheckPool validates sharing pool of name with other identities, it must be allowed explicitly
+func (p *Policy) CheckPool(name string) error {
+	if p != nil && p.all != nil {
+		return p.checkAll(func(policy *Policy) error { return policy.CheckPool(name) })
+	}
+	if p == nil || p.AllowedPools == nil || !matchAny(p.AllowedPools, name) {
+		return common.ErrPoolNotAllowed
+	}
+	return nil
+}
+
+func intersectFlags(a, b *string) *string {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	flags := ""
+	for _, flag := range *a {
+		if strings.ContainsRune(*b, flag) {
+			flags += string(flag)
+		}
+	}
+	return &flags
+}
+
+// policies returns policies combined in p
+func (p *Policy) policies() []*Policy {
+	if p.all != nil {
+		return p.all
+	}
+	return []*Policy{p}
+}
+
+// Merge returns policy which satisfies restrictions of both policies. Requests are checked against each of them,
+// only MaxForwards and AllowedFlags of the result are set
+func (p *Policy) Merge(other *Policy) *Policy {
+	if p == nil {
+		return other
+	}
+	if other == nil {
+		return p
+	}
+
+	maxForwards := p.MaxForwards
+	if maxForwards == 0 || (other.MaxForwards != 0 && other.MaxForwards < maxForwards) {
+		maxForwards = other.MaxForwards
+	}
+
+	all := append(append([]*Policy(nil), p.policies()...), other.policies()...)
+	return &Policy{
+		MaxForwards:  maxForwards,
+		AllowedFlags: intersectFlags(p.AllowedFlags, other.AllowedFlags),
+		all:          all,
+	}
+}
+
+// WithPolicy applies policy on top of policies returned by provider
+func WithPolicy(provider Provider, policy *Policy) Provider {
+	return Tiered(Tier{Provider: provider, Policy: policy})
+}
+
+// LoadPolicies reads JSON object of named policies
+func LoadPolicies(path string) (map[string]*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies map[string]*Policy
+	err = json.Unmarshal(data, &policies)
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
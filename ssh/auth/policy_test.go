@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"r-ssh/common"
+	"testing"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestPolicy_CheckForward(t *testing.T) {
+	policy := &Policy{
+		AllowedTargets:    []string{"localhost:*", "*.example.com:443"},
+		MaxForwards:       2,
+		AllowedFlags:      stringPtr("o"),
+		AllowedSubdomains: []string{"*.example.com"},
+	}
+
+	tests := []struct {
+		name     string
+		address  string
+		port     uint32
+		forwards int
+		want     error
+	}{
+		{name: "Default", address: common.DefaultForwardAddr, port: 8080, want: nil},
+		{name: "Custom subdomain", address: "app.example.com+o", port: 443, want: nil},
+		{name: "Limit", address: common.DefaultForwardAddr, port: 80, forwards: 2, want: common.ErrForwardLimitExceeded},
+		{name: "Target", address: "app.example.com", port: 80, want: common.ErrTargetNotAllowed},
+		{name: "Flag", address: "localhost+s", port: 80, want: common.ErrFlagNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := common.BuildForwardInfo("f", tt.address, tt.port)
+			if got := policy.CheckForward(info, tt.forwards); got != tt.want {
+				t.Errorf("CheckForward() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	subdomainPolicy := &Policy{AllowedSubdomains: []string{"app"}}
+	if err := subdomainPolicy.CheckForward(common.BuildForwardInfo("f", "other", 80), 0); err != common.ErrSubdomainNotAllowed {
+		t.Errorf("CheckForward() = %v, want %v", err, common.ErrSubdomainNotAllowed)
+	}
+
+	if err := subdomainPolicy.CheckForward(common.BuildNamedForwardInfo("other", 80), 0); err != common.ErrSubdomainNotAllowed {
+		t.Errorf("CheckForward() = %v, want %v", err, common.ErrSubdomainNotAllowed)
+	}
+	if err := subdomainPolicy.CheckForward(common.BuildNamedForwardInfo("app", 80), 0); err != nil {
+		t.Errorf("CheckForward() = %v, want nil", err)
+	}
+
+	socketPolicy := &Policy{AllowedTargets: []string{"/run/*.sock"}}
+	if err := socketPolicy.CheckForward(common.BuildSocketForwardInfo("f", "/run/app.sock"), 0); err != nil {
+		t.Errorf("CheckForward() = %v, want nil", err)
+	}
+	if err := socketPolicy.CheckForward(common.BuildSocketForwardInfo("f", "/tmp/app.sock"), 0); err != common.ErrTargetNotAllowed {
+		t.Errorf("CheckForward() = %v, want %v", err, common.ErrTargetNotAllowed)
+	}
+
+	var nilPolicy *Policy
+	if err := nilPolicy.CheckForward(common.BuildForwardInfo("f", "other+s", 1), 100); err != nil {
+		t.Errorf("nil CheckForward() = %v, want nil", err)
+	}
+}
+
+func TestPolicy_CheckDirect(t *testing.T) {
+	policy := &Policy{AllowedDirect: []string{"db-*"}}
+	if err := policy.CheckDirect("db-alice"); err != nil {
+		t.Errorf("CheckDirect() = %v, want nil", err)
+	}
+	if err := policy.CheckDirect("web-alice"); err != common.ErrDirectNotAllowed {
+		t.Errorf("CheckDirect() = %v, want %v", err, common.ErrDirectNotAllowed)
+	}
+	if err := (&Policy{AllowedDirect: []string{}}).CheckDirect("db-alice"); err != common.ErrDirectNotAllowed {
+		t.Errorf("empty CheckDirect() = %v, want %v", err, common.ErrDirectNotAllowed)
+	}
+
+	var nilPolicy *Policy
+	if err := nilPolicy.CheckDirect("db-alice"); err != common.ErrDirectNotAllowed {
+		t.Errorf("nil CheckDirect() = %v, want %v", err, common.ErrDirectNotAllowed)
+	}
+	if err := (&Policy{}).CheckDirect("db-alice"); err != common.ErrDirectNotAllowed {
+		t.Errorf("omitted CheckDirect() = %v, want %v", err, common.ErrDirectNotAllowed)
+	}
+}
+
+func TestPolicy_CheckPool(t *testing.T) {
+	policy := &Policy{AllowedPools: []string{"team-*"}}
+	if err := policy.CheckPool("team-api"); err != nil {
+		t.Errorf("CheckPool() = %v, want nil", err)
+	}
+	if err := policy.CheckPool("api"); err != common.ErrPoolNotAllowed {
+		t.Errorf("CheckPool() = %v, want %v", err, common.ErrPoolNotAllowed)
+	}
+
+	var nilPolicy *Policy
+	if err := nilPolicy.CheckPool("team-api"); err != common.ErrPoolNotAllowed {
+		t.Errorf("nil CheckPool() = %v, want %v", err, common.ErrPoolNotAllowed)
+	}
+	if err := (&Policy{}).CheckPool("team-api"); err != common.ErrPoolNotAllowed {
+		t.Errorf("omitted CheckPool() = %v, want %v", err, common.ErrPoolNotAllowed)
+	}
+}
+
+func TestPolicy_Merge(t *testing.T) {
+	a := &Policy{AllowedTargets: []string{"localhost:*", "a:*"}, MaxForwards: 5, AllowedFlags: stringPtr("so")}
+	b := &Policy{AllowedTargets: []string{"localhost:*"}, MaxForwards: 2, AllowedFlags: stringPtr("o")}
+
+	merged := a.Merge(b)
+	if merged.MaxForwards != 2 {
+		t.Errorf("Merge() MaxForwards = %d, want 2", merged.MaxForwards)
+	}
+	if *merged.AllowedFlags != "o" {
+		t.Errorf("Merge() AllowedFlags = %s, want o", *merged.AllowedFlags)
+	}
+	if err := merged.CheckForward(common.BuildForwardInfo("f", "localhost+o", 80), 1); err != nil {
+		t.Errorf("CheckForward() = %v, want nil", err)
+	}
+	if err := merged.CheckForward(common.BuildForwardInfo("f", "a", 80), 0); err != common.ErrTargetNotAllowed {
+		t.Errorf("CheckForward() = %v, want %v", err, common.ErrTargetNotAllowed)
+	}
+	if err := merged.CheckForward(common.BuildForwardInfo("f", "localhost", 80), 2); err != common.ErrForwardLimitExceeded {
+		t.Errorf("CheckForward() = %v, want %v", err, common.ErrForwardLimitExceeded)
+	}
+
+	disjoint := a.Merge(&Policy{AllowedTargets: []string{"b:*"}})
+	for _, address := range []string{"localhost", "a", "b"} {
+		if err := disjoint.CheckForward(common.BuildForwardInfo("f", address, 80), 0); err != common.ErrTargetNotAllowed {
+			t.Errorf("CheckForward(%s) of disjoint targets = %v, want %v", address, err, common.ErrTargetNotAllowed)
+		}
+	}
+}
+
+func TestPolicy_MergeOverlappingPatterns(t *testing.T) {
+	global := &Policy{
+		AllowedTargets: []string{"*:*"},
+		AllowedHosts:   []string{"*.example.com"},
+		AllowedDirect:  []string{"team-*"},
+		AllowedPools:   []string{"*"},
+	}
+	grant := &Policy{
+		AllowedTargets: []string{"localhost:*"},
+		AllowedHosts:   []string{"eu.example.com"},
+		AllowedDirect:  []string{"*-api"},
+		AllowedPools:   []string{"app-*"},
+	}
+
+	for _, merged := range []*Policy{global.Merge(grant), grant.Merge(global), global.Merge(grant.Merge(global))} {
+		if err := merged.CheckForward(common.BuildForwardInfo("f", "localhost", 8080), 0); err != nil {
+			t.Errorf("CheckForward() = %v, want nil", err)
+		}
+		if err := merged.CheckForward(common.BuildForwardInfo("f", "other", 8080), 0); err != common.ErrTargetNotAllowed {
+			t.Errorf("CheckForward() = %v, want %v", err, common.ErrTargetNotAllowed)
+		}
+		if err := merged.CheckHost("eu.example.com"); err != nil {
+			t.Errorf("CheckHost() = %v, want nil", err)
+		}
+		if err := merged.CheckHost("us.example.com"); err != common.ErrHostNotAllowed {
+			t.Errorf("CheckHost() = %v, want %v", err, common.ErrHostNotAllowed)
+		}
+		if err := merged.CheckDirect("team-api"); err != nil {
+			t.Errorf("CheckDirect() = %v, want nil", err)
+		}
+		if err := merged.CheckDirect("team-web"); err != common.ErrDirectNotAllowed {
+			t.Errorf("CheckDirect() = %v, want %v", err, common.ErrDirectNotAllowed)
+		}
+		if err := merged.CheckPool("app-1"); err != nil {
+			t.Errorf("CheckPool() = %v, want nil", err)
+		}
+	}
+
+	// pools and direct channels stay denied unless every policy allows them
+	if err := global.Merge(&Policy{}).CheckPool("app-1"); err != common.ErrPoolNotAllowed {
+		t.Errorf("CheckPool() = %v, want %v", err, common.ErrPoolNotAllowed)
+	}
+	if err := (&Policy{}).Merge(global).CheckDirect("team-api"); err != common.ErrDirectNotAllowed {
+		t.Errorf("CheckDirect() = %v, want %v", err, common.ErrDirectNotAllowed)
+	}
+
+	globs := (&Policy{AllowedTargets: []string{"local*:80*"}}).Merge(&Policy{AllowedTargets: []string{"localhost:*"}})
+	if err := globs.CheckForward(common.BuildForwardInfo("f", "localhost", 8080), 0); err != nil {
+		t.Errorf("CheckForward() = %v, want nil", err)
+	}
+	if err := globs.CheckForward(common.BuildForwardInfo("f", "localhost", 9090), 0); err != common.ErrTargetNotAllowed {
+		t.Errorf("CheckForward() = %v, want %v", err, common.ErrTargetNotAllowed)
+	}
+}
+
+func TestWithPolicy(t *testing.T) {
+	policy := &Policy{MaxForwards: 1}
+	grant, err := WithPolicy(DefaultAuthProvider, policy).Auth(newRequest(sshKey1))
+	if err != nil {
+		t.Fatalf("Auth() error: %s", err)
+	}
+	if grant.Policy.MaxForwards != 1 {
+		t.Errorf("Auth() policy = %v, want %v", grant.Policy, policy)
+	}
+}
+
+func TestPolicy_CheckHost(t *testing.T) {
+	policy := &Policy{AllowedHosts: []string{"*.internal"}}
+	if err := policy.CheckHost("tunnels.internal"); err != nil {
+		t.Errorf("CheckHost() = %v, want nil", err)
+	}
+	if err := policy.CheckHost("example.com"); err != common.ErrHostNotAllowed {
+		t.Errorf("CheckHost() = %v, want %v", err, common.ErrHostNotAllowed)
+	}
+
+	var nilPolicy *Policy
+	if err := nilPolicy.CheckHost("example.com"); err != nil {
+		t.Errorf("nil CheckHost() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"r-ssh/common"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+type revocationChecker interface {
+	IsRevoked(key ssh.PublicKey) bool
+}
+
+// fingerprintList is plain text list of revoked fingerprints (any format supported by common.NormalizeFingerprint)
+// or public keys in authorized_keys format
+type fingerprintList map[string]struct{}
+
+func (f fingerprintList) IsRevoked(key ssh.PublicKey) bool {
+	keys := []ssh.PublicKey{key}
+	if cert, ok := key.(*ssh.Certificate); ok {
+		keys = append(keys, cert.Key)
+	}
+
+	for _, k := range keys {
+		for _, fingerprint := range common.KeyFingerprints(k) {
+			if _, ok := f[fingerprint]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parseFingerprintList(data []byte) (fingerprintList, error) {
+	list := make(fingerprintList)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		if key, _, _, _, err := ssh.ParseAuthorizedKey(line); err == nil {
+			list[common.GetSHA256Fingerprint(key)] = struct{}{}
+			continue
+		}
+
+		fingerprint, err := common.NormalizeFingerprint(string(line))
+		if err != nil {
+			return nil, err
+		}
+		list[fingerprint] = struct{}{}
+	}
+	return list, nil
+}
+
+func parseRevocationList(data []byte) (revocationChecker, error) {
+	if IsKRL(data) {
+		return ParseKRL(data)
+	}
+	return parseFingerprintList(data)
+}
+
+// RevocationList is loaded from OpenSSH KRL or plain fingerprint list and is checked before any provider
+type RevocationList struct {
+	path string
+
+	checkerLock sync.RWMutex
+	checker     revocationChecker
+
+	logger *logrus.Entry
+}
+
+func (r *RevocationList) Reload() error {
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+
+	checker, err := parseRevocationList(data)
+	if err != nil {
+		return err
+	}
+
+	r.checkerLock.Lock()
+	r.checker = checker
+	r.checkerLock.Unlock()
+
+	r.logger.Info("revocation list loaded")
+	return nil
+}
+
+// Watch reloads the list on change and calls onReload after successful reload
+func (r *RevocationList) Watch(interval time.Duration, onReload func()) (stop func()) {
+	return common.WatchFile(r.path, interval, func() {
+		if err := r.Reload(); err != nil {
+			r.logger.WithError(err).Warnln("reload revocation list failed")
+			return
+		}
+		onReload()
+	})
+}
+
+func (r *RevocationList) IsRevoked(key ssh.PublicKey) bool {
+	r.checkerLock.RLock()
+	defer r.checkerLock.RUnlock()
+
+	return r.checker.IsRevoked(key)
+}
+
+func NewRevocationList(path string) (*RevocationList, error) {
+	list := &RevocationList{
+		path:   path,
+		logger: logrus.WithField("component", "revocation"),
+	}
+	if err := list.Reload(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+type revokedProvider struct {
+	list *RevocationList
+}
+
+func (r *revokedProvider) Auth(req *Request) (*Grant, error) {
+	if req.PublicKey == nil || !r.list.IsRevoked(req.PublicKey) {
+		return nil, common.ErrAuthNotAllowed
+	}
+	return NewGrant(req), nil
+}
+
+// Revoked accepts only revoked keys, it is meant to be used as Not(Revoked(list)) in chains
+func Revoked(list *RevocationList) Provider {
+	return &revokedProvider{list: list}
+}
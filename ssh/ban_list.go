@@ -0,0 +1,129 @@
+package ssh
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+type failureRecord struct {
+	count int
+	since time.Time
+}
+
+// BanList counts auth failures per remote ip and bans ips which fail too often
+type BanList struct {
+	maxFailures int
+	window      time.Duration
+	duration    time.Duration
+
+	lock      sync.Mutex
+	failures  map[string]*failureRecord
+	bans      map[string]time.Time
+	lastPrune time.Time
+
+	now func() time.Time
+}
+
+func (b *BanList) prune(now time.Time) {
+	for ip, record := range b.failures {
+		if now.Sub(record.since) > b.window {
+			delete(b.failures, ip)
+		}
+	}
+	for ip, until := range b.bans {
+		if !now.Before(until) {
+			delete(b.bans, ip)
+		}
+	}
+	b.lastPrune = now
+}
+
+func (b *BanList) maybePrune(now time.Time) {
+	if now.Sub(b.lastPrune) > b.window {
+		b.prune(now)
+	}
+}
+
+// Failure records failed auth attempt and returns true if ip got banned
+func (b *BanList) Failure(ip net.IP) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.now()
+	b.maybePrune(now)
+
+	key := ip.String()
+	record, ok := b.failures[key]
+	if !ok || now.Sub(record.since) > b.window {
+		record = &failureRecord{since: now}
+		b.failures[key] = record
+	}
+	record.count++
+
+	if record.count < b.maxFailures {
+		return false
+	}
+	delete(b.failures, key)
+	b.bans[key] = now.Add(b.duration)
+	return true
+}
+
+// Success forgets failures of ip
+func (b *BanList) Success(ip net.IP) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.failures, ip.String())
+}
+
+func (b *BanList) IsBanned(ip net.IP) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	until, ok := b.bans[ip.String()]
+	return ok && b.now().Before(until)
+}
+
+// Bans returns banned ips with the time their ban expires
+func (b *BanList) Bans() map[string]time.Time {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.prune(b.now())
+	bans := make(map[string]time.Time, len(b.bans))
+	for ip, until := range b.bans {
+		bans[ip] = until
+	}
+	return bans
+}
+
+// Unban removes ban and failures of ip, empty ip clears the whole list
+func (b *BanList) Unban(ip string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if ip == "" {
+		b.failures = make(map[string]*failureRecord)
+		b.bans = make(map[string]time.Time)
+		return
+	}
+
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	delete(b.failures, ip)
+	delete(b.bans, ip)
+}
+
+// NewBanList bans ip for duration after maxFailures failed attempts within window
+func NewBanList(maxFailures int, window, duration time.Duration) *BanList {
+	return &BanList{
+		maxFailures: maxFailures,
+		window:      window,
+		duration:    duration,
+		failures:    make(map[string]*failureRecord),
+		bans:        make(map[string]time.Time),
+		now:         time.Now,
+	}
+}
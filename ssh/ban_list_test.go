@@ -0,0 +1,64 @@
+package ssh
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	now := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	banList := NewBanList(3, time.Minute, time.Hour)
+	banList.now = func() time.Time { return now }
+
+	ip := net.ParseIP("192.0.2.1")
+	other := net.ParseIP("192.0.2.2")
+
+	if banList.Failure(ip) || banList.Failure(ip) {
+		t.Fatal("Failure() banned too early")
+	}
+	banList.Success(ip)
+	if banList.Failure(ip) || banList.Failure(ip) {
+		t.Fatal("Success() must reset failures")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if banList.Failure(ip) || banList.Failure(ip) {
+		t.Fatal("failures outside of window must be forgotten")
+	}
+	if !banList.Failure(ip) {
+		t.Fatal("Failure() must ban after max failures")
+	}
+	if !banList.IsBanned(ip) || banList.IsBanned(other) {
+		t.Errorf("IsBanned() = %v, %v, want true, false", banList.IsBanned(ip), banList.IsBanned(other))
+	}
+	if bans := banList.Bans(); len(bans) != 1 || !bans[ip.String()].Equal(now.Add(time.Hour)) {
+		t.Errorf("Bans() = %v", bans)
+	}
+
+	now = now.Add(time.Hour)
+	if banList.IsBanned(ip) {
+		t.Error("ban must expire")
+	}
+	if bans := banList.Bans(); len(bans) != 0 {
+		t.Errorf("Bans() = %v, want empty", bans)
+	}
+}
+
+func TestBanList_Unban(t *testing.T) {
+	banList := NewBanList(1, time.Minute, time.Hour)
+	ip1 := net.ParseIP("192.0.2.1")
+	ip2 := net.ParseIP("2001:db8::1")
+	banList.Failure(ip1)
+	banList.Failure(ip2)
+
+	banList.Unban("2001:0db8::1")
+	if !banList.IsBanned(ip1) || banList.IsBanned(ip2) {
+		t.Error("Unban() must remove only given ip")
+	}
+
+	banList.Unban("")
+	if banList.IsBanned(ip1) {
+		t.Error("Unban(\"\") must clear all bans")
+	}
+}
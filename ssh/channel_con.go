@@ -2,31 +2,248 @@ package ssh
 
 import (
 	"golang.org/x/crypto/ssh"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// channelReadSize is the most data read from the channel ahead of the reader
+const channelReadSize = 32 * 1024
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var errTimeout net.Error = timeoutError{}
+
+// deadline is closed channel once the time passes
+type deadline struct {
+	lock   sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// timer already fired, wait for it to close cancel
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if duration := time.Until(t); duration > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(duration, func() { close(cancel) })
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns channel closed when the deadline passes
+func (d *deadline) wait() chan struct{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.cancel
+}
+
+// active reports the deadline is set
+func (d *deadline) active() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.timer != nil || isClosed(d.cancel)
+}
+
+// channelConn is net.Conn over ssh channel. Channel reads can't be interrupted, so they're done by readLoop
+// and Read waits for the data until read deadline. Blocked write can't be resumed, write deadline closes the channel
 type channelConn struct {
 	localAddr  net.Addr
 	remoteAddr net.Addr
 	channel    ssh.Channel
+
+	readDeadline  *deadline
+	writeDeadline *deadline
+
+	readLock sync.Mutex
+	chunks   chan []byte
+	pending  []byte
+	// readErr is set before chunks is closed
+	readErr    error
+	peerClosed int32
+	timedOut   int32
+
+	closeOnce sync.Once
+	closed    chan struct{}
 }
 
-func (c *channelConn) Read(b []byte) (n int, err error)  { return c.channel.Read(b) }
-func (c *channelConn) Write(b []byte) (n int, err error) { return c.channel.Write(b) }
-func (c *channelConn) LocalAddr() net.Addr               { return c.localAddr }
-func (c *channelConn) RemoteAddr() net.Addr              { return c.remoteAddr }
-func (c *channelConn) SetDeadline(time.Time) error       { return nil }
-func (c *channelConn) SetReadDeadline(time.Time) error   { return nil }
-func (c *channelConn) SetWriteDeadline(time.Time) error  { return nil }
-func (c *channelConn) Close() error {
+func (c *channelConn) readLoop() {
+	defer close(c.chunks)
+
+	buf := make([]byte, channelReadSize)
+	for {
+		n, err := c.channel.Read(buf)
+		if err != nil {
+			atomic.StoreInt32(&c.peerClosed, 1)
+		}
+		if n > 0 {
+			select {
+			case c.chunks <- append([]byte(nil), buf[:n]...):
+			case <-c.closed:
+				return
+			}
+		}
+		if err != nil {
+			c.readErr = err
+			return
+		}
+	}
+}
+
+func (c *channelConn) Read(b []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	if len(c.pending) == 0 {
+		select {
+		case chunk, ok := <-c.chunks:
+			if !ok {
+				if c.readErr != nil {
+					return 0, c.readErr
+				}
+				return 0, io.EOF
+			}
+			c.pending = chunk
+		case <-c.readDeadline.wait():
+			return 0, errTimeout
+		case <-c.closed:
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *channelConn) Write(b []byte) (int, error) {
+	if !c.writeDeadline.active() {
+		return c.channel.Write(b)
+	}
+	cancel := c.writeDeadline.wait()
+	if isClosed(cancel) {
+		return 0, errTimeout
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-cancel:
+			atomic.StoreInt32(&c.timedOut, 1)
+			_ = c.Close()
+		case <-done:
+		}
+	}()
+
+	n, err := c.channel.Write(b)
+	close(done)
+	if err != nil && atomic.LoadInt32(&c.timedOut) == 1 {
+		err = errTimeout
+	}
+	return n, err
+}
+
+func (c *channelConn) LocalAddr() net.Addr  { return c.localAddr }
+func (c *channelConn) RemoteAddr() net.Addr { return c.remoteAddr }
+
+func (c *channelConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
 	return nil
 }
 
+func (c *channelConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *channelConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *channelConn) Close() error {
+	err := io.EOF
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.channel.Close()
+	})
+	return err
+}
+
+func (c *channelConn) CloseWrite() error {
+	return c.channel.CloseWrite()
+}
+
+// PeerClosed reports the client closed the channel, e.g. the target closed keep-alive connection
+func (c *channelConn) PeerClosed() bool {
+	return atomic.LoadInt32(&c.peerClosed) == 1
+}
+
 func NewChannelConn(localAddr, remoteAddr net.Addr, channel ssh.Channel) net.Conn {
-	return &channelConn{
-		localAddr:  localAddr,
-		remoteAddr: remoteAddr,
-		channel:    channel,
+	conn := &channelConn{
+		localAddr:     localAddr,
+		remoteAddr:    remoteAddr,
+		channel:       channel,
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		chunks:        make(chan []byte),
+		closed:        make(chan struct{}),
 	}
+	go conn.readLoop()
+	return conn
+}
+
+// countedChannel counts its first close, channels which are never closed show up in ForwardController.ChannelStats
+type countedChannel struct {
+	ssh.Channel
+	closeOnce sync.Once
+	closed    *uint64
+}
+
+func (c *countedChannel) Close() error {
+	c.closeOnce.Do(func() {
+		atomic.AddUint64(c.closed, 1)
+	})
+	return c.Channel.Close()
 }
@@ -0,0 +1,115 @@
+package ssh
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipeChannel is ssh channel reading from and writing to in-memory pipes
+type pipeChannel struct {
+	io.Reader
+	io.Writer
+	closed bool
+}
+
+func (c *pipeChannel) Close() error {
+	c.closed = true
+	if closer, ok := c.Reader.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	if closer, ok := c.Writer.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	return nil
+}
+
+func (c *pipeChannel) CloseWrite() error { return nil }
+func (c *pipeChannel) SendRequest(string, bool, []byte) (bool, error) {
+	return false, nil
+}
+func (c *pipeChannel) Stderr() io.ReadWriter { return nil }
+
+func newPipeConn() (net.Conn, *io.PipeWriter, *pipeChannel) {
+	reader, writer := io.Pipe()
+	channel := &pipeChannel{Reader: reader, Writer: ioutil.Discard}
+	return NewChannelConn(&net.TCPAddr{}, &net.TCPAddr{}, channel), writer, channel
+}
+
+func TestChannelConn_ReadDeadline(t *testing.T) {
+	conn, writer, _ := newPipeConn()
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	buf := make([]byte, 4)
+	_, err := conn.Read(buf)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("Read() error = %v, want timeout", err)
+	}
+
+	// timeout doesn't break the connection
+	_ = conn.SetReadDeadline(time.Time{})
+	go func() { _, _ = writer.Write([]byte("data")) }()
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "data" {
+		t.Errorf("Read() = %q, %v, want data", buf[:n], err)
+	}
+}
+
+func TestChannelConn_DeadlineUnblocksRead(t *testing.T) {
+	conn, _, _ := newPipeConn()
+	defer conn.Close()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = conn.SetReadDeadline(time.Now())
+	}()
+	if _, err := conn.Read(make([]byte, 4)); err != errTimeout {
+		t.Errorf("Read() error = %v, want timeout", err)
+	}
+}
+
+func TestChannelConn_WriteDeadline(t *testing.T) {
+	// nobody reads the pipe, so the write blocks like on full channel window
+	_, blocked := io.Pipe()
+	channel := &pipeChannel{Reader: &io.LimitedReader{}, Writer: blocked}
+	conn := NewChannelConn(&net.TCPAddr{}, &net.TCPAddr{}, channel)
+
+	_ = conn.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := conn.Write([]byte("data")); err != errTimeout {
+		t.Errorf("Write() error = %v, want timeout", err)
+	}
+	if !channel.closed {
+		t.Error("write timeout didn't close the channel")
+	}
+}
+
+func TestChannelConn_PeerClosed(t *testing.T) {
+	conn, writer, channel := newPipeConn()
+
+	_ = writer.Close()
+	if _, err := conn.Read(make([]byte, 4)); err != io.EOF {
+		t.Errorf("Read() error = %v, want EOF", err)
+	}
+	if !conn.(*channelConn).PeerClosed() {
+		t.Error("PeerClosed() = false, want true")
+	}
+
+	_ = conn.Close()
+	if !channel.closed {
+		t.Error("Close() didn't close the channel")
+	}
+}
+
+func TestCountedChannel_Close(t *testing.T) {
+	var closed uint64
+	channel := &countedChannel{Channel: &pipeChannel{}, closed: &closed}
+
+	_ = channel.Close()
+	_ = channel.Close()
+	if closed != 1 {
+		t.Errorf("closed = %d, want 1", closed)
+	}
+}
@@ -1,12 +1,71 @@
 package ssh
 
 import (
+	"encoding/hex"
 	"golang.org/x/crypto/ssh"
+	"r-ssh/ssh/audit"
+	"r-ssh/ssh/auth"
 	"r-ssh/ssh/terminal"
+	"sync"
 )
 
 type ConnectionWrapper struct {
-	Connection  *ssh.ServerConn
+	Connection *ssh.ServerConn
+	// PublicKey is nil for password authentication
+	PublicKey   ssh.PublicKey
 	Fingerprint string
-	Terminal    *terminal.BasicTerminal
+	// Identity is "<source>:<label>", e.g. "key:<fingerprint>" or "cert:<principal>", subdomains are derived from the label
+	Identity string
+	// LegacyFingerprint is set only during migration from md5 subdomains
+	LegacyFingerprint string
+	Terminal          *terminal.BasicTerminal
+	// Policy is enforced by ForwardController, nil means unrestricted
+	Policy *auth.Policy
+
+	DisplayName string
+	Metadata    map[string]string
+
+	closeLock   sync.Mutex
+	closeReason string
+}
+
+// CloseWithReason closes connection, reason is reported in audit log
+func (c *ConnectionWrapper) CloseWithReason(reason string) error {
+	c.closeLock.Lock()
+	if c.closeReason == "" {
+		c.closeReason = reason
+	}
+	c.closeLock.Unlock()
+
+	return c.Connection.Close()
+}
+
+func (c *ConnectionWrapper) disconnectReason(err error) string {
+	c.closeLock.Lock()
+	defer c.closeLock.Unlock()
+
+	switch {
+	case c.closeReason != "":
+		return c.closeReason
+	case err != nil:
+		return err.Error()
+	default:
+		return "closed by client"
+	}
+}
+
+func (c *ConnectionWrapper) auditEvent(eventType audit.EventType) *audit.Event {
+	event := connectionEvent(eventType, c.Connection)
+	event.Fingerprint = c.Fingerprint
+	event.Identity = c.Identity
+	return event
+}
+
+func connectionEvent(eventType audit.EventType, conn ssh.ConnMetadata) *audit.Event {
+	return &audit.Event{
+		Type:       eventType,
+		Session:    hex.EncodeToString(conn.SessionID()),
+		RemoteAddr: conn.RemoteAddr().String(),
+		User:       conn.User(),
+	}
 }
@@ -0,0 +1,110 @@
+package ssh
+
+import (
+	"net"
+	"r-ssh/common"
+	"r-ssh/ssh/audit"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// directTCPIPRequest is payload of "direct-tcpip" channel, RFC 4254 section 7.2
+type directTCPIPRequest struct {
+	Host       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// directSubdomain accepts both "<subdomain>" and "<subdomain>.<host>" of any base domain, the base domain is
+// empty for bare subdomains
+func (f *ForwardController) directSubdomain(address string) (subdomain, host string) {
+	address = strings.ToLower(address)
+	for _, base := range f.hosts {
+		if strings.HasSuffix(address, "."+strings.ToLower(base)) {
+			return address[:len(address)-len(base)-1], base
+		}
+	}
+	return address, ""
+}
+
+// acquireDirect selects forward addressed by direct-tcpip request, the port must match the port of the forward unless it's unix socket.
+// Forwards of other identities are reachable only when the policy allows them
+func (f *ForwardController) acquireDirect(conn *ConnectionWrapper, subdomain, host string, port uint32) (*Forward, error) {
+	if !f.options.DirectTCPIP {
+		return nil, common.ErrDirectTCPIPDisabled
+	}
+
+	forward, err := f.AcquireForward(host, subdomain, "")
+	if err != nil {
+		return nil, err
+	}
+	if forward.Identity != conn.Identity {
+		if err := conn.Policy.CheckDirect(subdomain); err != nil {
+			forward.Release()
+			return nil, err
+		}
+	}
+	if forward.Info.SocketPath == "" && forward.Info.Port != port {
+		forward.Release()
+		return nil, common.ErrForwardNotFound
+	}
+	return forward, nil
+}
+
+func (f *ForwardController) auditDirect(conn *ConnectionWrapper, msg *directTCPIPRequest, subdomain, host string, err error) {
+	event := conn.auditEvent(audit.EventDirect)
+	event.Bind = net.JoinHostPort(msg.Host, strconv.Itoa(int(msg.Port)))
+	if host == "" {
+		host = f.host
+	}
+	event.URL = f.forwardURL(subdomain, host)
+	event.Result = audit.ResultAllowed
+	if err != nil {
+		event.Result = audit.ResultDenied
+		event.Error = err.Error()
+	}
+	f.options.AuditLog.Log(event)
+}
+
+// HandleDirectTCPIP bridges direct-tcpip channel to forwarded channel of the addressed forward
+func (f *ForwardController) HandleDirectTCPIP(conn *ConnectionWrapper, newChannel ssh.NewChannel) {
+	logger := common.NewConnectionLog(conn.Connection)
+
+	var msg directTCPIPRequest
+	err := ssh.Unmarshal(newChannel.ExtraData(), &msg)
+	if err != nil {
+		logger.WithError(err).Warnln("parse direct-tcpip failed")
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	subdomain, host := f.directSubdomain(msg.Host)
+	forward, err := f.acquireDirect(conn, subdomain, host, msg.Port)
+	if err != nil {
+		f.auditDirect(conn, &msg, subdomain, host, err)
+		_ = newChannel.Reject(ssh.Prohibited, err.Error())
+		return
+	}
+	defer forward.Release()
+
+	target, _, err := forward.Handler(conn.Connection.RemoteAddr())
+	if err != nil {
+		f.auditDirect(conn, &msg, subdomain, host, err)
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		_ = target.Close()
+		logger.WithError(err).Warnln("accept direct-tcpip failed")
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	f.auditDirect(conn, &msg, subdomain, host, nil)
+	common.Pipe(channel, target)
+}
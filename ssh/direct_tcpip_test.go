@@ -0,0 +1,100 @@
+package ssh
+
+import (
+	"io"
+	"r-ssh/common"
+	"r-ssh/ssh/auth"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestForwardController_acquireDirect(t *testing.T) {
+	alice := &ConnectionWrapper{Identity: "cert:alice"}
+	f := NewForwardController("example.com", ServerOptions{DirectTCPIP: true})
+	f.redirects["app"] = newRoute(t, "app", alice)
+
+	tests := []struct {
+		name      string
+		conn      *ConnectionWrapper
+		subdomain string
+		port      uint32
+		want      error
+	}{
+		{name: "Own forward", conn: &ConnectionWrapper{Identity: "cert:alice"}, subdomain: "app", port: 80},
+		{name: "Other identity", conn: &ConnectionWrapper{Identity: "cert:bob"}, subdomain: "app", port: 80, want: common.ErrDirectNotAllowed},
+		{name: "Allowed other identity", conn: &ConnectionWrapper{Identity: "cert:bob", Policy: &auth.Policy{AllowedDirect: []string{"app"}}}, subdomain: "app", port: 80},
+		{name: "Other port", conn: alice, subdomain: "app", port: 22, want: common.ErrForwardNotFound},
+		{name: "Missing", conn: alice, subdomain: "other", port: 80, want: common.ErrForwardNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forward, err := f.acquireDirect(tt.conn, tt.subdomain, "", tt.port)
+			if err != tt.want {
+				t.Fatalf("acquireDirect() = %v, want %v", err, tt.want)
+			}
+			if forward != nil {
+				forward.Release()
+			}
+		})
+	}
+
+	f.options.DirectTCPIP = false
+	if _, err := f.acquireDirect(alice, "app", "", 80); err != common.ErrDirectTCPIPDisabled {
+		t.Errorf("acquireDirect() = %v, want %v", err, common.ErrDirectTCPIPDisabled)
+	}
+}
+
+// echoForwards accepts forwarded channels of client and echoes their data
+func echoForwards(client *ssh.Client) {
+	for newChannel := range client.HandleChannelOpen(forwardedChannelType) {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go ssh.DiscardRequests(requests)
+		go func() {
+			_, _ = io.Copy(channel, channel)
+			_ = channel.Close()
+		}()
+	}
+}
+
+func TestForwardController_HandleDirectTCPIP(t *testing.T) {
+	server := newTestServer(t, auth.DefaultAuthProvider, ServerOptions{DirectTCPIP: true})
+
+	alice := newTestSigner(t)
+	owner, err := connect(t, server, ssh.PublicKeys(alice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer owner.Close()
+	go echoForwards(owner)
+	if !requestForward(t, owner, common.DefaultForwardAddr, 8080) {
+		t.Fatal("forward failed")
+	}
+	target := "8080-" + common.GetFingerprint(alice.PublicKey()) + ".example.com:8080"
+
+	conn, err := owner.Dial("tcp", target)
+	if err != nil {
+		t.Fatalf("Dial() own forward error: %s", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 4)
+	if _, err = io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Errorf("read = %q, %v, want ping", reply, err)
+	}
+
+	other, err := connect(t, server, ssh.PublicKeys(newTestSigner(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if conn, err := other.Dial("tcp", target); err == nil {
+		_ = conn.Close()
+		t.Error("Dial() of other identity's forward must be rejected")
+	}
+}
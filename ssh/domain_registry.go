@@ -0,0 +1,269 @@
+package ssh
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"r-ssh/common"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	challengeTimeout = 5 * time.Second
+
+	// pending claims expire after claimTTL, an identity may have up to maxIdentityClaims of them and a domain up to
+	// maxDomainClaims, so unverified claims can't grow the registry without bounds
+	claimTTL          = 7 * 24 * time.Hour
+	maxIdentityClaims = 10
+	maxDomainClaims   = 10
+)
+
+type DomainRecord struct {
+	Identity string    `json:"identity"`
+	Token    string    `json:"token"`
+	Verified bool      `json:"verified"`
+	Created  time.Time `json:"created"`
+}
+
+// DomainRegistry persists custom domains claimed by identities. Every identity claims pending domain with its own
+// token, the first verified claim makes the domain belong to its identity and drops claims of others
+type DomainRegistry struct {
+	path string
+
+	// lookupTXT is replaced in tests
+	lookupTXT func(name string) ([]string, error)
+
+	lock    sync.Mutex
+	domains map[string][]*DomainRecord
+}
+
+func newToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// lookupChallenge resolves TXT records, the lookup is limited by challengeTimeout
+func lookupChallenge(name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), challengeTimeout)
+	defer cancel()
+	return net.DefaultResolver.LookupTXT(ctx, name)
+}
+
+// ChallengeName returns name of TXT record verifying the domain
+func ChallengeName(domain string) string {
+	return "_rssh-challenge." + domain
+}
+
+// claim returns claim of identity, nil if it has none
+func (d *DomainRegistry) claim(domain, identity string) *DomainRecord {
+	for _, record := range d.domains[domain] {
+		if record.Identity == identity {
+			return record
+		}
+	}
+	return nil
+}
+
+// pending returns number of unverified claims of identity
+func (d *DomainRegistry) pending(identity string) int {
+	count := 0
+	for _, claims := range d.domains {
+		for _, record := range claims {
+			if record.Identity == identity && !record.Verified {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// expire drops pending claims older than claimTTL, the registry is saved with the next change
+func (d *DomainRegistry) expire() {
+	deadline := time.Now().Add(-claimTTL)
+	for domain, claims := range d.domains {
+		var kept []*DomainRecord
+		for _, record := range claims {
+			if record.Verified || record.Created.After(deadline) {
+				kept = append(kept, record)
+			}
+		}
+		d.setClaims(domain, kept)
+	}
+}
+
+// Claim registers domain to identity, token of the returned record has to be published to verify the domain.
+// Pending claims of other identities are kept, the domain is taken only once verified
+func (d *DomainRegistry) Claim(domain, identity string) (DomainRecord, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.expire()
+	if owner, ok := d.owner(domain); ok && owner != identity {
+		return DomainRecord{}, common.ErrDomainTaken
+	}
+	if record := d.claim(domain, identity); record != nil {
+		return *record, nil
+	}
+
+	if len(d.domains[domain]) >= maxDomainClaims || d.pending(identity) >= maxIdentityClaims {
+		return DomainRecord{}, common.ErrTooManyDomainClaims
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return DomainRecord{}, err
+	}
+
+	claims := d.domains[domain]
+	record := &DomainRecord{Identity: identity, Token: token, Created: time.Now().UTC()}
+	d.domains[domain] = append(claims, record)
+	if err = d.save(); err != nil {
+		d.setClaims(domain, claims)
+		return DomainRecord{}, err
+	}
+	return *record, nil
+}
+
+// check looks up the token in TXT record of the domain
+func (d *DomainRegistry) check(domain, token string) error {
+	records, _ := d.lookupTXT(ChallengeName(domain))
+	for _, record := range records {
+		if strings.TrimSpace(record) == token {
+			return nil
+		}
+	}
+	return common.ErrDomainNotVerified
+}
+
+// Verify checks pending claim of identity, challenges run without holding the lock
+func (d *DomainRegistry) Verify(domain, identity string) error {
+	d.lock.Lock()
+	d.expire()
+	record := d.claim(domain, identity)
+	if record == nil {
+		d.lock.Unlock()
+		return common.ErrDomainNotFound
+	}
+	if record.Verified {
+		d.lock.Unlock()
+		return nil
+	}
+	token := record.Token
+	d.lock.Unlock()
+
+	if err := d.check(domain, token); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// the claim might be released or other claim verified meanwhile
+	record = d.claim(domain, identity)
+	if record == nil || record.Token != token {
+		return common.ErrDomainNotVerified
+	}
+	if owner, ok := d.owner(domain); ok && owner != identity {
+		return common.ErrDomainTaken
+	}
+
+	claims := d.domains[domain]
+	record.Verified = true
+	d.domains[domain] = []*DomainRecord{record}
+	if err := d.save(); err != nil {
+		record.Verified = false
+		d.domains[domain] = claims
+		return err
+	}
+	return nil
+}
+
+// Owner returns identity owning verified domain
+func (d *DomainRegistry) Owner(domain string) (string, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.owner(domain)
+}
+
+func (d *DomainRegistry) owner(domain string) (string, bool) {
+	for _, record := range d.domains[domain] {
+		if record.Verified {
+			return record.Identity, true
+		}
+	}
+	return "", false
+}
+
+func (d *DomainRegistry) setClaims(domain string, claims []*DomainRecord) {
+	if len(claims) == 0 {
+		delete(d.domains, domain)
+		return
+	}
+	d.domains[domain] = claims
+}
+
+func (d *DomainRegistry) Release(domain string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	claims, ok := d.domains[domain]
+	if !ok {
+		return common.ErrDomainNotFound
+	}
+
+	delete(d.domains, domain)
+	if err := d.save(); err != nil {
+		d.domains[domain] = claims
+		return err
+	}
+	return nil
+}
+
+func (d *DomainRegistry) Domains() map[string][]DomainRecord {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.expire()
+	domains := make(map[string][]DomainRecord, len(d.domains))
+	for domain, claims := range d.domains {
+		for _, record := range claims {
+			domains[domain] = append(domains[domain], *record)
+		}
+	}
+	return domains
+}
+
+func (d *DomainRegistry) load() error {
+	var domains map[string][]*DomainRecord
+	if err := loadJSON(d.path, &domains); err != nil {
+		return err
+	}
+	if domains != nil {
+		d.domains = domains
+	}
+	d.expire()
+	return nil
+}
+
+func (d *DomainRegistry) save() error {
+	return saveJSON(d.path, d.domains)
+}
+
+// NewDomainRegistry loads registry from path
+func NewDomainRegistry(path string) (*DomainRegistry, error) {
+	registry := &DomainRegistry{
+		path:      path,
+		lookupTXT: lookupChallenge,
+		domains:   make(map[string][]*DomainRecord),
+	}
+	if err := registry.load(); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
@@ -0,0 +1,205 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"r-ssh/common"
+	"testing"
+	"time"
+)
+
+func newTestDomainRegistry(t *testing.T) (*DomainRegistry, string) {
+	dir, err := ioutil.TempDir("", "domains")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "domains.json")
+
+	registry, err := NewDomainRegistry(path)
+	if err != nil {
+		t.Fatalf("NewDomainRegistry() error: %s", err)
+	}
+	registry.lookupTXT = func(string) ([]string, error) { return nil, errors.New("no such host") }
+	return registry, path
+}
+
+func TestDomainRegistry_Claim(t *testing.T) {
+	registry, path := newTestDomainRegistry(t)
+	domain := "demo.customer.com"
+
+	alice, err := registry.Claim(domain, "alice")
+	if err != nil {
+		t.Fatalf("Claim() error: %s", err)
+	}
+	if again, _ := registry.Claim(domain, "alice"); again.Token != alice.Token {
+		t.Error("Claim() by the same identity changed token")
+	}
+
+	// other identity gets its own token, pending claim of alice is kept
+	bob, err := registry.Claim(domain, "bob")
+	if err != nil || bob.Token == alice.Token {
+		t.Fatalf("Claim() of pending domain = %v, %v", bob, err)
+	}
+	if again, _ := registry.Claim(domain, "alice"); again.Token != alice.Token {
+		t.Error("Claim() by other identity replaced pending claim")
+	}
+
+	registry.lookupTXT = func(name string) ([]string, error) {
+		if name != "_rssh-challenge."+domain {
+			t.Errorf("lookupTXT(%q)", name)
+		}
+		return []string{"other", bob.Token}, nil
+	}
+	if err = registry.Verify(domain, "bob"); err != nil {
+		t.Fatalf("Verify() error: %s", err)
+	}
+	if _, err = registry.Claim(domain, "alice"); err != common.ErrDomainTaken {
+		t.Errorf("Claim() of verified domain = %v, want %v", err, common.ErrDomainTaken)
+	}
+	if err = registry.Verify(domain, "alice"); err != common.ErrDomainNotFound {
+		t.Errorf("Verify() of dropped claim = %v, want %v", err, common.ErrDomainNotFound)
+	}
+
+	registry, err = NewDomainRegistry(path)
+	if err != nil {
+		t.Fatalf("NewDomainRegistry() reload error: %s", err)
+	}
+	if owner, ok := registry.Owner(domain); !ok || owner != "bob" {
+		t.Errorf("Owner() = %s, %v, want bob, true", owner, ok)
+	}
+	if claims := registry.Domains()[domain]; len(claims) != 1 {
+		t.Errorf("Domains() = %v, want only verified claim", claims)
+	}
+}
+
+func TestDomainRegistry_Verify(t *testing.T) {
+	registry, _ := newTestDomainRegistry(t)
+	domain := "demo.customer.com"
+
+	alice, err := registry.Claim(domain, "alice")
+	if err != nil {
+		t.Fatalf("Claim() error: %s", err)
+	}
+	if err = registry.Verify(domain, "alice"); err != common.ErrDomainNotVerified {
+		t.Errorf("Verify() = %v, want %v", err, common.ErrDomainNotVerified)
+	}
+	if _, ok := registry.Owner(domain); ok {
+		t.Error("Owner() of pending domain is set")
+	}
+
+	registry.lookupTXT = func(string) ([]string, error) { return []string{alice.Token}, nil }
+	if err = registry.Verify(domain, "alice"); err != nil {
+		t.Fatalf("Verify() error: %s", err)
+	}
+	if owner, ok := registry.Owner(domain); !ok || owner != "alice" {
+		t.Errorf("Owner() = %s, %v, want alice, true", owner, ok)
+	}
+
+	if err = registry.Release(domain); err != nil {
+		t.Fatalf("Release() error: %s", err)
+	}
+	if _, ok := registry.Owner(domain); ok {
+		t.Error("Owner() of released domain is set")
+	}
+}
+
+func TestDomainRegistry_takeover(t *testing.T) {
+	registry, _ := newTestDomainRegistry(t)
+	domain := "demo.customer.com"
+
+	// the domain already points to the server, only alice published her token
+	alice, err := registry.Claim(domain, "alice")
+	if err != nil {
+		t.Fatalf("Claim() error: %s", err)
+	}
+	registry.lookupTXT = func(string) ([]string, error) { return []string{alice.Token}, nil }
+
+	if _, err = registry.Claim(domain, "mallory"); err != nil {
+		t.Fatalf("Claim() error: %s", err)
+	}
+	if err = registry.Verify(domain, "mallory"); err != common.ErrDomainNotVerified {
+		t.Errorf("Verify() by other identity = %v, want %v", err, common.ErrDomainNotVerified)
+	}
+	if err = registry.Verify(domain, "alice"); err != nil {
+		t.Fatalf("Verify() error: %s", err)
+	}
+	if owner, _ := registry.Owner(domain); owner != "alice" {
+		t.Errorf("Owner() = %s, want alice", owner)
+	}
+	if _, err = registry.Claim(domain, "mallory"); err != common.ErrDomainTaken {
+		t.Errorf("Claim() of verified domain = %v, want %v", err, common.ErrDomainTaken)
+	}
+}
+
+func TestDomainRegistry_claimLimits(t *testing.T) {
+	registry, _ := newTestDomainRegistry(t)
+
+	for i := 0; i < maxIdentityClaims; i++ {
+		if _, err := registry.Claim(fmt.Sprintf("app%d.customer.com", i), "mallory"); err != nil {
+			t.Fatalf("Claim() error: %s", err)
+		}
+	}
+	if _, err := registry.Claim("other.customer.com", "mallory"); err != common.ErrTooManyDomainClaims {
+		t.Errorf("Claim() over identity limit = %v, want %v", err, common.ErrTooManyDomainClaims)
+	}
+	if _, err := registry.Claim("app0.customer.com", "mallory"); err != nil {
+		t.Errorf("Claim() of pending domain again = %v, want nil", err)
+	}
+
+	domain := "demo.customer.com"
+	for i := 0; i < maxDomainClaims; i++ {
+		if _, err := registry.Claim(domain, fmt.Sprintf("user%d", i)); err != nil {
+			t.Fatalf("Claim() error: %s", err)
+		}
+	}
+	if _, err := registry.Claim(domain, "alice"); err != common.ErrTooManyDomainClaims {
+		t.Errorf("Claim() over domain limit = %v, want %v", err, common.ErrTooManyDomainClaims)
+	}
+}
+
+func TestDomainRegistry_expire(t *testing.T) {
+	registry, path := newTestDomainRegistry(t)
+	domain := "demo.customer.com"
+
+	alice, err := registry.Claim(domain, "alice")
+	if err != nil {
+		t.Fatalf("Claim() error: %s", err)
+	}
+	registry.lookupTXT = func(string) ([]string, error) { return []string{alice.Token}, nil }
+	if err = registry.Verify(domain, "alice"); err != nil {
+		t.Fatalf("Verify() error: %s", err)
+	}
+	if _, err = registry.Claim("stale.customer.com", "bob"); err != nil {
+		t.Fatalf("Claim() error: %s", err)
+	}
+
+	// both claims are older than claimTTL, only the verified one is kept
+	for _, claims := range registry.domains {
+		for _, record := range claims {
+			record.Created = record.Created.Add(-claimTTL - time.Minute)
+		}
+	}
+	if err = registry.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = registry.Verify("stale.customer.com", "bob"); err != common.ErrDomainNotFound {
+		t.Errorf("Verify() of expired claim = %v, want %v", err, common.ErrDomainNotFound)
+	}
+	if owner, ok := registry.Owner(domain); !ok || owner != "alice" {
+		t.Errorf("Owner() = %s, %v, want alice, true", owner, ok)
+	}
+
+	// expired claims are dropped on load as well
+	reloaded, err := NewDomainRegistry(path)
+	if err != nil {
+		t.Fatalf("NewDomainRegistry() reload error: %s", err)
+	}
+	if domains := reloaded.Domains(); len(domains) != 1 || len(domains[domain]) != 1 {
+		t.Errorf("Domains() = %v, want only verified claim", domains)
+	}
+}
@@ -5,155 +5,542 @@ import (
 	"golang.org/x/crypto/ssh"
 	"net"
 	"r-ssh/common"
+	"r-ssh/ssh/audit"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type ForwardHandler func(origin net.Addr) (net.Conn, *common.ForwardInfo, error)
 
 type ForwardController struct {
+	// channel counters are first, 64-bit atomic values have to be aligned on 32-bit platforms
+	channelsOpened uint64
+	channelsClosed uint64
+
 	redirectLock  sync.Mutex
-	redirects     map[string]ForwardHandler
-	subdomainsMap map[*ConnectionWrapper]map[string]struct{}
-	host          string
+	redirects     map[string]*route
+	memberSeq     uint64
+	subdomainsMap map[*ConnectionWrapper]map[string]*common.ForwardInfo
+	tcpForwards   map[*ConnectionWrapper]map[string]*tcpForward
+	// host is the primary base domain, hosts are all base domains starting with it
+	host    string
+	hosts   []string
+	options ServerOptions
 }
 
-func (f *ForwardController) HandleRequest(connection *ConnectionWrapper, req *ssh.Request) (interface{}, error) {
-	var msg portForwardRequest
+// forwardURL is url of subdomain of base domain host or of custom domain
+func (f *ForwardController) forwardURL(subdomain, host string) string {
+	if strings.Contains(subdomain, ".") {
+		return fmt.Sprintf("https://%s/", subdomain)
+	}
+	return fmt.Sprintf("https://%s.%s/", subdomain, host)
+}
 
-	err := ssh.Unmarshal(req.Payload, &msg)
-	if err != nil {
-		return nil, err
+// forwardURLs are urls of subdomain under every base domain the forward is published under
+func (f *ForwardController) forwardURLs(subdomain string, info *common.ForwardInfo) []string {
+	if strings.Contains(subdomain, ".") {
+		return []string{f.forwardURL(subdomain, "")}
+	}
+
+	urls := make([]string, 0, len(info.Hosts))
+	for _, host := range info.Hosts {
+		urls = append(urls, f.forwardURL(subdomain, host))
+	}
+	return urls
+}
+
+// formatURLs quotes urls for the terminal
+func formatURLs(urls []string) string {
+	return "\"" + strings.Join(urls, "\", \"") + "\""
+}
+
+// tunnelEvent builds audit event of subdomain forward, events are logged outside of redirectLock
+func (f *ForwardController) tunnelEvent(eventType audit.EventType, conn *ConnectionWrapper, subdomain string, info *common.ForwardInfo, reason string) *audit.Event {
+	event := conn.auditEvent(eventType)
+	event.Bind = info.Bind()
+	// the first url is under the primary host when the forward is published there
+	if urls := f.forwardURLs(subdomain, info); len(urls) != 0 {
+		event.URL = urls[0]
+		event.URLs = urls
+	}
+	event.Reason = reason
+	return event
+}
+
+func (f *ForwardController) logEvents(events []*audit.Event) {
+	for _, event := range events {
+		f.options.AuditLog.Log(event)
 	}
+}
 
+func (f *ForwardController) HandleRequest(connection *ConnectionWrapper, req *ssh.Request) (interface{}, error) {
 	switch req.Type {
-	case "tcpip-forward":
-		return f.handleForward(connection, msg.Address, msg.Port)
-	case "cancel-tcpip-forward":
-		return f.handleForwardCancel(connection, req.Payload)
+	case "tcpip-forward", "cancel-tcpip-forward":
+		var msg portForwardRequest
+		if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
+			return nil, err
+		}
+
+		info := f.forwardInfo(connection, msg.Address, msg.Port)
+		if req.Type == "cancel-tcpip-forward" {
+			return f.handleForwardCancel(connection, info)
+		}
+		return f.handleForward(connection, info)
+	case "streamlocal-forward@openssh.com", "cancel-streamlocal-forward@openssh.com":
+		var msg streamLocalForwardRequest
+		if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
+			return nil, err
+		}
+
+		info := f.socketForwardInfo(connection, msg.SocketPath)
+		if req.Type == "cancel-streamlocal-forward@openssh.com" {
+			return f.handleForwardCancel(connection, info)
+		}
+		return f.handleForward(connection, info)
 	default:
 		return nil, common.ErrUnknownRequestType
 	}
 }
 
-func (f *ForwardController) createForwardHandler(connection *ConnectionWrapper, info *common.ForwardInfo) ForwardHandler {
-	return func(origin net.Addr) (net.Conn, *common.ForwardInfo, error) {
+// forwardResponse reports bound port, unix socket forwards have no response data
+func forwardResponse(info *common.ForwardInfo) interface{} {
+	if info.SocketPath != "" {
+		return nil
+	}
+	return portForwardResponse{Port: info.Port}
+}
+
+// openForwardChannel opens forwarded-tcpip (or forwarded-streamlocal) channel to the client for connection from origin
+func (f *ForwardController) openForwardChannel(connection *ConnectionWrapper, info *common.ForwardInfo, origin net.Addr) (ssh.Channel, error) {
+	channelType := forwardedStreamLocalChannelType
+	payload := ssh.Marshal(&forwardedStreamLocalData{SocketPath: info.SocketPath})
+
+	if info.SocketPath == "" {
 		originAddr, originPortRaw, _ := net.SplitHostPort(origin.String())
 		originPort, err := strconv.Atoi(originPortRaw)
 		if err != nil {
-			return nil, info, err
+			return nil, err
 		}
 
-		payload := ssh.Marshal(&remoteForwardData{
+		channelType = forwardedChannelType
+		payload = ssh.Marshal(&remoteForwardData{
 			DestAddress:   info.Address,
 			DestPort:      info.Port,
 			OriginAddress: originAddr,
 			OriginPort:    uint32(originPort),
 		})
+	}
+
+	channel, reqs, err := connection.Connection.OpenChannel(channelType, payload)
+	if err != nil {
+		_ = connection.Connection.Close()
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+	atomic.AddUint64(&f.channelsOpened, 1)
+	return &countedChannel{Channel: channel, closed: &f.channelsClosed}, nil
+}
 
-		channel, reqs, err := connection.Connection.OpenChannel(forwardedChannelType, payload)
+// ChannelStats returns number of forward channels opened and closed from our side, the difference are channels in use
+func (f *ForwardController) ChannelStats() (opened, closed uint64) {
+	return atomic.LoadUint64(&f.channelsOpened), atomic.LoadUint64(&f.channelsClosed)
+}
+
+func (f *ForwardController) createForwardHandler(connection *ConnectionWrapper, info *common.ForwardInfo) ForwardHandler {
+	return func(origin net.Addr) (net.Conn, *common.ForwardInfo, error) {
+		channel, err := f.openForwardChannel(connection, info, origin)
 		if err != nil {
-			_ = connection.Connection.Close()
 			return nil, info, err
 		}
-		go ssh.DiscardRequests(reqs)
 		return NewChannelConn(connection.Connection.LocalAddr(), connection.Connection.RemoteAddr(), channel), info, nil
 	}
 }
 
-func (f *ForwardController) addForwardHandler(conn *ConnectionWrapper, subdomain string, handler ForwardHandler) error {
+func (f *ForwardController) addForwardHandler(conn *ConnectionWrapper, subdomain string, info *common.ForwardInfo, handler ForwardHandler) error {
 	f.redirectLock.Lock()
 	defer f.redirectLock.Unlock()
 
-	_, ok := f.redirects[subdomain]
-	if ok {
+	r, ok := f.redirects[subdomain]
+	if !ok {
+		// members of shared pool only join it, the name owner creates it
+		if !f.ownsName(conn, info) {
+			return common.ErrNameTaken
+		}
+		r = &route{}
+	}
+	if r.member(conn) != nil {
 		return common.ErrForwardAlreadyBinded
 	}
 
-	f.redirects[subdomain] = handler
+	f.memberSeq++
+	err := r.add(&routeMember{
+		id:      strconv.FormatUint(f.memberSeq, 36),
+		conn:    conn,
+		handler: handler,
+		info:    info,
+	})
+	if err != nil {
+		return err
+	}
+	f.redirects[subdomain] = r
+
 	subdomains, ok := f.subdomainsMap[conn]
 	if !ok {
-		subdomains = make(map[string]struct{})
+		subdomains = make(map[string]*common.ForwardInfo)
 		f.subdomainsMap[conn] = subdomains
 	}
-	subdomains[subdomain] = struct{}{}
+	subdomains[subdomain] = info
 	return nil
 }
 
-func (f *ForwardController) removeForwardHandler(conn *ConnectionWrapper, subdomain string) {
+func (f *ForwardController) ownsName(conn *ConnectionWrapper, info *common.ForwardInfo) bool {
+	if f.options.NameRegistry == nil || info.Name == "" {
+		return true
+	}
+	owner, ok := f.options.NameRegistry.Owner(info.Name)
+	return !ok || owner == conn.Identity
+}
+
+// forwardCount returns number of active forwards of connection, legacy aliases are not counted
+func (f *ForwardController) forwardCount(conn *ConnectionWrapper) int {
 	f.redirectLock.Lock()
 	defer f.redirectLock.Unlock()
-	delete(f.redirects, subdomain)
 
-	subdomains, ok := f.subdomainsMap[conn]
+	count := len(f.tcpForwards[conn])
+	for subdomain, info := range f.subdomainsMap[conn] {
+		if subdomain == info.Subdomain {
+			count++
+		}
+	}
+	return count
+}
+
+// removeRouteMember removes connection from route, the route is deleted with its last member
+func (f *ForwardController) removeRouteMember(conn *ConnectionWrapper, subdomain string) {
+	r, ok := f.redirects[subdomain]
+	if ok && r.remove(conn) && len(r.members) == 0 {
+		delete(f.redirects, subdomain)
+	}
+}
+
+func (f *ForwardController) removeForwardHandler(conn *ConnectionWrapper, subdomain string) {
+	f.redirectLock.Lock()
+	f.removeRouteMember(conn, subdomain)
+	subdomains := f.subdomainsMap[conn]
+	info, ok := subdomains[subdomain]
+	delete(subdomains, subdomain)
+	f.redirectLock.Unlock()
+
 	if ok {
-		delete(subdomains, subdomain)
+		f.options.AuditLog.Log(f.tunnelEvent(audit.EventTunnelClose, conn, subdomain, info, "cancel"))
+	}
+}
+
+// forwardInfo treats single label bind addresses as reserved names when name registry is enabled
+func (f *ForwardController) forwardInfo(conn *ConnectionWrapper, address string, port uint32) *common.ForwardInfo {
+	info := common.BuildForwardInfo(common.IdentityLabel(conn.Identity), address, port)
+	if info.CustomDomain && !info.TCP {
+		return common.BuildDomainForwardInfo(address, port)
+	}
+	if f.options.NameRegistry != nil && !info.TCP && common.IsName(info.Host) {
+		return common.BuildNamedForwardInfo(address, port)
+	}
+	return info
+}
+
+// socketForwardInfo is forwardInfo of unix socket forward, socket names may be reserved names as well
+func (f *ForwardController) socketForwardInfo(conn *ConnectionWrapper, socketPath string) *common.ForwardInfo {
+	if f.options.NameRegistry != nil {
+		named := common.BuildNamedSocketForwardInfo(socketPath)
+		if !named.TCP && common.IsName(named.Name) {
+			return named
+		}
+	}
+	return common.BuildSocketForwardInfo(common.IdentityLabel(conn.Identity), socketPath)
+}
+
+func (f *ForwardController) legacySubdomain(conn *ConnectionWrapper, info *common.ForwardInfo) string {
+	if conn.LegacyFingerprint == "" || common.KeyIdentity(conn.LegacyFingerprint) == conn.Identity || info.Name != "" || info.SocketPath != "" || info.CustomDomain {
+		return ""
+	}
+	return common.BuildForwardInfo(conn.LegacyFingerprint, info.Address, info.Port).Subdomain
+}
+
+// checkName claims reserved name for connection identity, subdomains registered as names are usable only by their owner
+func (f *ForwardController) checkName(conn *ConnectionWrapper, info *common.ForwardInfo) error {
+	if f.options.NameRegistry == nil {
+		return nil
+	}
+	if info.Name != "" {
+		err := f.options.NameRegistry.Claim(info.Name, conn.Identity)
+		// pool of the name may be joined if its members allow it, see route.add
+		if err == common.ErrNameTaken && info.Pooled() && conn.Policy.CheckPool(info.Name) == nil {
+			return nil
+		}
+		return err
+	}
+	if owner, ok := f.options.NameRegistry.Owner(info.Subdomain); ok && owner != conn.Identity {
+		return common.ErrNameTaken
+	}
+	return nil
+}
+
+// checkDomain claims custom domain for connection identity, the forward is accepted once the domain is verified
+func (f *ForwardController) checkDomain(conn *ConnectionWrapper, info *common.ForwardInfo) error {
+	if !info.CustomDomain {
+		return nil
+	}
+	registry := f.options.DomainRegistry
+	if registry == nil {
+		return common.ErrCustomDomainsDisabled
+	}
+	if !common.IsDomain(info.Subdomain) {
+		return common.ErrInvalidDomain
+	}
+	for _, host := range f.hosts {
+		if info.Subdomain == host || strings.HasSuffix(info.Subdomain, "."+host) {
+			return common.ErrInvalidDomain
+		}
+	}
+
+	record, err := registry.Claim(info.Subdomain, conn.Identity)
+	if err != nil || record.Verified {
+		return err
+	}
+
+	err = registry.Verify(info.Subdomain, conn.Identity)
+	if err != common.ErrDomainNotVerified {
+		return err
+	}
+
+	_, _ = conn.Terminal.WriteString(fmt.Sprintf("verify \"%s\" by TXT record \"%s\" with value \"%s\", then forward again\r\n",
+		info.Subdomain, ChallengeName(info.Subdomain), record.Token))
+	return err
+}
+
+// publishHosts returns base domains allowed by the session policy and by policies of the hosts,
+// the forward fails when no host allows it
+func (f *ForwardController) publishHosts(conn *ConnectionWrapper, info *common.ForwardInfo, forwards int) ([]string, error) {
+	var hosts []string
+	var lastErr error
+	for _, host := range f.hosts {
+		err := conn.Policy.CheckHost(host)
+		if err == nil {
+			err = f.options.HostPolicies[host].CheckForward(info, forwards)
+		}
+		if err != nil {
+			if len(f.hosts) > 1 {
+				_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" not published under \"%s\": \"%s\"\r\n", info.Bind(), host, err))
+			}
+			lastErr = err
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	if len(hosts) == 0 {
+		return nil, lastErr
+	}
+	return hosts, nil
+}
+
+// checkTCPHosts validates tcp forward against policies of all hosts, the port is reachable under every one of them
+func (f *ForwardController) checkTCPHosts(conn *ConnectionWrapper, info *common.ForwardInfo, forwards int) error {
+	for _, host := range f.hosts {
+		err := conn.Policy.CheckHost(host)
+		if err == nil {
+			err = f.options.HostPolicies[host].CheckForward(info, forwards)
+		}
+		if err != nil {
+			return fmt.Errorf("%w under \"%s\"", err, host)
+		}
 	}
+	info.Hosts = f.hosts
+	return nil
 }
 
-func (f *ForwardController) handleForward(conn *ConnectionWrapper, address string, port uint32) (interface{}, error) {
-	if port == 0 {
-		_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s:%d\" failed: \"%s\"\r\n", address, port, common.ErrPortNotAllowed))
+func (f *ForwardController) handleForward(conn *ConnectionWrapper, forwardInfo *common.ForwardInfo) (interface{}, error) {
+	if forwardInfo.SocketPath == "" && forwardInfo.Port == 0 {
+		_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" failed: \"%s\"\r\n", forwardInfo.Bind(), common.ErrPortNotAllowed))
 		return nil, common.ErrPortNotAllowed
 	}
-	forwardInfo := common.BuildForwardInfo(conn.Fingerprint, address, port)
 
-	forwardHandler := f.createForwardHandler(conn, forwardInfo)
-	err := f.addForwardHandler(conn, forwardInfo.Subdomain, forwardHandler)
+	forwards := f.forwardCount(conn)
+	err := conn.Policy.CheckForward(forwardInfo, forwards)
 	if err != nil {
-		_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s:%d\" failed: \"%s\"\r\n", address, port, err))
+		_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" rejected by policy: \"%s\"\r\n", forwardInfo.Bind(), err))
 		return nil, err
 	}
 
-	_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s:%d\" to \"https://%s.%s/\"\r\n", address, port, forwardInfo.Subdomain, f.host))
-	return portForwardResponse{Port: port}, nil
-}
+	if forwardInfo.TCP {
+		err = f.checkTCPHosts(conn, forwardInfo, forwards)
+		if err != nil {
+			_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" rejected by policy: \"%s\"\r\n", forwardInfo.Bind(), err))
+			return nil, err
+		}
+		return f.handleTCPForward(conn, forwardInfo)
+	}
 
-func (f *ForwardController) handleForwardCancel(conn *ConnectionWrapper, payload []byte) ([]byte, error) {
-	var msg portForwardRequest
+	if !forwardInfo.CustomDomain {
+		forwardInfo.Hosts, err = f.publishHosts(conn, forwardInfo, forwards)
+		if err != nil {
+			_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" rejected by policy: \"%s\"\r\n", forwardInfo.Bind(), err))
+			return nil, err
+		}
+	}
 
-	err := ssh.Unmarshal(payload, &msg)
+	err = f.checkName(conn, forwardInfo)
+	if err == nil {
+		err = f.checkDomain(conn, forwardInfo)
+	}
 	if err != nil {
+		_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" failed: \"%s\"\r\n", forwardInfo.Bind(), err))
 		return nil, err
 	}
 
-	info := common.BuildForwardInfo(conn.Fingerprint, msg.Address, msg.Port)
+	forwardHandler := f.createForwardHandler(conn, forwardInfo)
+	err = f.addForwardHandler(conn, forwardInfo.Subdomain, forwardInfo, forwardHandler)
+	if err == common.ErrForwardAlreadyBinded && f.options.SessionTakeover && f.takeover(conn, forwardInfo.Subdomain) {
+		err = f.addForwardHandler(conn, forwardInfo.Subdomain, forwardInfo, forwardHandler)
+	}
+	if err != nil {
+		_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" failed: \"%s\"\r\n", forwardInfo.Bind(), err))
+		return nil, err
+	}
+
+	if legacySubdomain := f.legacySubdomain(conn, forwardInfo); legacySubdomain != "" {
+		if err := f.addForwardHandler(conn, legacySubdomain, forwardInfo, forwardHandler); err != nil {
+			_, _ = conn.Terminal.WriteString(fmt.Sprintf("legacy forward \"%s\" failed: \"%s\"\r\n", forwardInfo.Bind(), err))
+		} else {
+			f.options.AuditLog.Log(f.tunnelEvent(audit.EventTunnelOpen, conn, legacySubdomain, forwardInfo, ""))
+			_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" to %s (deprecated)\r\n", forwardInfo.Bind(), formatURLs(f.forwardURLs(legacySubdomain, forwardInfo))))
+		}
+	}
+
+	f.options.AuditLog.Log(f.tunnelEvent(audit.EventTunnelOpen, conn, forwardInfo.Subdomain, forwardInfo, ""))
+	_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" to %s\r\n", forwardInfo.Bind(), formatURLs(f.forwardURLs(forwardInfo.Subdomain, forwardInfo))))
+	return forwardResponse(forwardInfo), nil
+}
+
+func (f *ForwardController) handleForwardCancel(conn *ConnectionWrapper, info *common.ForwardInfo) (interface{}, error) {
+	if info.TCP {
+		f.removeTCPForward(conn, info)
+		return nil, nil
+	}
+
 	f.removeForwardHandler(conn, info.Subdomain)
+	if legacySubdomain := f.legacySubdomain(conn, info); legacySubdomain != "" {
+		f.removeForwardHandler(conn, legacySubdomain)
+	}
 	return nil, nil
 }
 
-func (f *ForwardController) GetForwardHandler(subdomain string) (ForwardHandler, error) {
+// AcquireForward selects forward serving subdomain of base domain host, empty host matches custom domains
+// and any base domain. stickyID is member id remembered by the client for sticky pools
+func (f *ForwardController) AcquireForward(host, subdomain, stickyID string) (*Forward, error) {
 	f.redirectLock.Lock()
 	defer f.redirectLock.Unlock()
 
-	handler, ok := f.redirects[subdomain]
-	if !ok {
+	r, ok := f.redirects[subdomain]
+	// members of the route share hosts
+	if !ok || (host != "" && !r.members[0].info.PublishedOn(host)) {
 		return nil, common.ErrForwardNotFound
 	}
-	return handler, nil
+
+	member := r.pick(stickyID)
+	member.active++
+	return &Forward{
+		Handler:  member.handler,
+		Info:     member.info,
+		MemberID: member.id,
+		Identity: member.conn.Identity,
+		release: func() {
+			f.redirectLock.Lock()
+			member.active--
+			f.redirectLock.Unlock()
+		},
+	}, nil
 }
 
-func (f *ForwardController) Shutdown(conn *ConnectionWrapper) error {
-	f.redirectLock.Lock()
-	defer f.redirectLock.Unlock()
+// detach removes all forwards of connection, routes joined by other connections stay bound to them.
+// It returns audit events of closed forwards, false if connection had none
+func (f *ForwardController) detach(conn *ConnectionWrapper, reason string) ([]*audit.Event, bool) {
+	var events []*audit.Event
+	tcpForwards, hasTCP := f.tcpForwards[conn]
+	delete(f.tcpForwards, conn)
+	for _, forward := range tcpForwards {
+		events = append(events, f.closeTCPForward(conn, forward, reason))
+	}
 
 	subdomains, ok := f.subdomainsMap[conn]
 	if !ok {
-		return common.ErrForwardNotFound
+		return events, hasTCP
 	}
 	delete(f.subdomainsMap, conn)
 
-	for subdomain := range subdomains {
-		delete(f.redirects, subdomain)
+	for subdomain, info := range subdomains {
+		f.removeRouteMember(conn, subdomain)
+		events = append(events, f.tunnelEvent(audit.EventTunnelClose, conn, subdomain, info, reason))
 	}
+	return events, true
+}
+
+// takeover detaches and closes other sessions of the same identity holding subdomain, it returns false if there was none
+func (f *ForwardController) takeover(conn *ConnectionWrapper, subdomain string) bool {
+	f.redirectLock.Lock()
+	r, ok := f.redirects[subdomain]
+	if !ok {
+		f.redirectLock.Unlock()
+		return false
+	}
+
+	var stale []*ConnectionWrapper
+	for _, member := range r.members {
+		if member.conn == conn {
+			continue
+		}
+		if member.conn.Identity != conn.Identity {
+			f.redirectLock.Unlock()
+			return false
+		}
+		stale = append(stale, member.conn)
+	}
+	var events []*audit.Event
+	for _, old := range stale {
+		detached, _ := f.detach(old, "takeover")
+		events = append(events, detached...)
+	}
+	f.redirectLock.Unlock()
+	f.logEvents(events)
 
+	for _, old := range stale {
+		common.NewConnectionLog(old.Connection).WithField("subdomain", subdomain).Infoln("session taken over")
+		_, _ = old.Terminal.WriteString(fmt.Sprintf("session taken over by \"%s\"\r\n", conn.Connection.RemoteAddr()))
+		_ = old.CloseWithReason("takeover")
+	}
+	return len(stale) > 0
+}
+
+func (f *ForwardController) Shutdown(conn *ConnectionWrapper) error {
+	f.redirectLock.Lock()
+	events, ok := f.detach(conn, "disconnect")
+	f.redirectLock.Unlock()
+
+	f.logEvents(events)
+	if !ok {
+		return common.ErrForwardNotFound
+	}
 	return nil
 }
 
-func NewForwardController(host string) *ForwardController {
+func NewForwardController(host string, options ServerOptions) *ForwardController {
 	return &ForwardController{
 		host:          host,
-		redirects:     make(map[string]ForwardHandler),
-		subdomainsMap: make(map[*ConnectionWrapper]map[string]struct{}),
+		hosts:         append([]string{host}, options.ExtraHosts...),
+		options:       options,
+		redirects:     make(map[string]*route),
+		subdomainsMap: make(map[*ConnectionWrapper]map[string]*common.ForwardInfo),
+		tcpForwards:   make(map[*ConnectionWrapper]map[string]*tcpForward),
 	}
 }
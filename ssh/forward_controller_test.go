@@ -0,0 +1,214 @@
+package ssh
+
+import (
+	"path/filepath"
+	"r-ssh/common"
+	"r-ssh/ssh/audit"
+	"r-ssh/ssh/auth"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestForwardController_forwardURLs(t *testing.T) {
+	f := NewForwardController("example.com", ServerOptions{ExtraHosts: []string{"example.internal"}})
+
+	info := &common.ForwardInfo{Hosts: []string{"example.com", "example.internal"}}
+	want := []string{"https://app.example.com/", "https://app.example.internal/"}
+	if got := f.forwardURLs("app", info); !reflect.DeepEqual(got, want) {
+		t.Errorf("forwardURLs() = %v, want %v", got, want)
+	}
+
+	want = []string{"https://demo.customer.com/"}
+	if got := f.forwardURLs("demo.customer.com", &common.ForwardInfo{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("forwardURLs() = %v, want %v", got, want)
+	}
+}
+
+func TestForwardController_AcquireForward(t *testing.T) {
+	f := NewForwardController("example.com", ServerOptions{ExtraHosts: []string{"example.internal"}})
+
+	r := newRoute(t, "app", &ConnectionWrapper{Identity: "alice"})
+	r.members[0].info.Hosts = []string{"example.internal"}
+	f.redirects["app"] = r
+
+	tests := []struct {
+		name string
+		host string
+		want error
+	}{
+		{name: "Published host", host: "example.internal", want: nil},
+		{name: "Any host", host: "", want: nil},
+		{name: "Other host", host: "example.com", want: common.ErrForwardNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forward, err := f.AcquireForward(tt.host, "app", "")
+			if err != tt.want {
+				t.Fatalf("AcquireForward() = %v, want %v", err, tt.want)
+			}
+			if forward != nil {
+				forward.Release()
+			}
+		})
+	}
+}
+
+func TestForwardController_directSubdomain(t *testing.T) {
+	f := NewForwardController("example.com", ServerOptions{ExtraHosts: []string{"example.internal"}})
+
+	tests := []struct {
+		address       string
+		wantSubdomain string
+		wantHost      string
+	}{
+		{address: "app", wantSubdomain: "app", wantHost: ""},
+		{address: "App.Example.com", wantSubdomain: "app", wantHost: "example.com"},
+		{address: "app.example.internal", wantSubdomain: "app", wantHost: "example.internal"},
+	}
+	for _, tt := range tests {
+		subdomain, host := f.directSubdomain(tt.address)
+		if subdomain != tt.wantSubdomain || host != tt.wantHost {
+			t.Errorf("directSubdomain(%q) = %q, %q, want %q, %q", tt.address, subdomain, host, tt.wantSubdomain, tt.wantHost)
+		}
+	}
+}
+
+// lockProbe fails audit writes done while redirectLock is held
+type lockProbe struct {
+	auditBuffer
+	f    *ForwardController
+	held int32
+}
+
+func (p *lockProbe) Write(b []byte) (int, error) {
+	acquired := make(chan struct{})
+	go func() {
+		p.f.redirectLock.Lock()
+		p.f.redirectLock.Unlock()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		atomic.AddInt32(&p.held, 1)
+	}
+	return p.auditBuffer.Write(b)
+}
+
+func TestForwardController_auditOutsideLock(t *testing.T) {
+	probe := &lockProbe{}
+	server := newTestServer(t, auth.DefaultAuthProvider, ServerOptions{AuditLog: audit.NewLogger(probe)})
+	probe.f = server.ForwardController()
+
+	client, err := connect(t, server, ssh.PublicKeys(newTestSigner(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, address := range []string{"0.0.0.0:80", "0.0.0.0:8080"} {
+		listener, err := client.Listen("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the first forward is cancelled, the second closed on disconnect
+		if address == "0.0.0.0:80" {
+			_ = listener.Close()
+		}
+	}
+	_ = client.Close()
+
+	for i := 0; i < 50 && len(probe.events(t, audit.EventTunnelClose)) < 2; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if got := len(probe.events(t, audit.EventTunnelClose)); got != 2 {
+		t.Fatalf("tunnel close events = %d, want 2", got)
+	}
+	if held := atomic.LoadInt32(&probe.held); held != 0 {
+		t.Errorf("%d audit events written under redirectLock", held)
+	}
+}
+
+// requestForward sends tcpip-forward request, unlike client.Listen it allows flags in the address
+func requestForward(t *testing.T, client *ssh.Client, address string, port uint32) bool {
+	ok, _, err := client.SendRequest("tcpip-forward", true, ssh.Marshal(&portForwardRequest{Address: address, Port: port}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+type providerFunc func(req *auth.Request) (*auth.Grant, error)
+
+func (f providerFunc) Auth(req *auth.Request) (*auth.Grant, error) {
+	return f(req)
+}
+
+func TestForwardController_sharedPool(t *testing.T) {
+	alice, bob, mallory := newTestSigner(t), newTestSigner(t), newTestSigner(t)
+	team := map[string]bool{
+		common.GetFingerprint(alice.PublicKey()): true,
+		common.GetFingerprint(bob.PublicKey()):   true,
+	}
+	provider := providerFunc(func(req *auth.Request) (*auth.Grant, error) {
+		grant := auth.NewGrant(req)
+		if team[req.Fingerprint] {
+			grant.Policy = &auth.Policy{AllowedPools: []string{"app"}}
+		}
+		return grant, nil
+	})
+	registry, err := NewNameRegistry(filepath.Join(t.TempDir(), "names.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t, provider, ServerOptions{NameRegistry: registry})
+
+	clients := make(map[ssh.Signer]*ssh.Client)
+	for _, signer := range []ssh.Signer{alice, bob, mallory} {
+		client, err := connect(t, server, ssh.PublicKeys(signer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients[signer] = client
+	}
+
+	if !requestForward(t, clients[alice], "app+r", 80) {
+		t.Fatal("forward of name owner failed")
+	}
+	if requestForward(t, clients[mallory], "app+r", 80) {
+		t.Error("identity outside of the team joined the pool")
+	}
+	if !requestForward(t, clients[bob], "app+r", 80) {
+		t.Fatal("teammate didn't join the pool")
+	}
+
+	f := server.ForwardController()
+	f.redirectLock.Lock()
+	members := len(f.redirects["app"].members)
+	f.redirectLock.Unlock()
+	if members != 2 {
+		t.Errorf("pool members = %d, want 2", members)
+	}
+
+	_ = clients[alice].Close()
+	_ = clients[bob].Close()
+	for i := 0; i < 50; i++ {
+		forward, err := f.AcquireForward("", "app", "")
+		if err == common.ErrForwardNotFound {
+			break
+		}
+		forward.Release()
+		time.Sleep(100 * time.Millisecond)
+	}
+	client, err := connect(t, server, ssh.PublicKeys(bob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if requestForward(t, client, "app+r", 80) {
+		t.Error("teammate created pool of name owned by other identity")
+	}
+}
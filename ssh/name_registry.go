@@ -0,0 +1,164 @@
+package ssh
+
+import (
+	"r-ssh/common"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type NameRecord struct {
+	Identity string    `json:"identity"`
+	Created  time.Time `json:"created"`
+	// Reserved records hold label of identity, subdomains derived from it belong to the identity
+	Reserved bool `json:"reserved,omitempty"`
+}
+
+// NameRegistry persists ownership of reserved subdomain names, a name belongs to the identity which used it first
+type NameRegistry struct {
+	path string
+	// maxNames limits names claimed by single identity, 0 is unlimited
+	maxNames int
+
+	lock  sync.Mutex
+	names map[string]*NameRecord
+}
+
+func (n *NameRegistry) load() error {
+	var names map[string]*NameRecord
+	if err := loadJSON(n.path, &names); err != nil {
+		return err
+	}
+	if names != nil {
+		n.names = names
+	}
+	return nil
+}
+
+func (n *NameRegistry) save() error {
+	return saveJSON(n.path, n.names)
+}
+
+// Owner returns identity owning the name
+func (n *NameRegistry) Owner(name string) (string, bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	record, ok := n.names[name]
+	if !ok {
+		return "", false
+	}
+	return record.Identity, true
+}
+
+// Claim registers free name to identity, names of other identities are never taken over. Names in namespace
+// of identity label reserved by other identity ("<label>", "8080-<label>") are taken as well
+func (n *NameRegistry) Claim(name, identity string) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if record, ok := n.names[name]; ok {
+		if record.Identity != identity {
+			return common.ErrNameTaken
+		}
+		return nil
+	}
+	if record, ok := n.names[common.NameSuffix(name)]; ok && record.Reserved && record.Identity != identity {
+		return common.ErrNameTaken
+	}
+	if n.maxNames > 0 && n.count(identity) >= n.maxNames {
+		return common.ErrTooManyNames
+	}
+
+	n.names[name] = &NameRecord{Identity: identity, Created: time.Now().UTC()}
+	if err := n.save(); err != nil {
+		delete(n.names, name)
+		return err
+	}
+	return nil
+}
+
+// count returns number of names claimed by identity, reserved labels are not counted
+func (n *NameRegistry) count(identity string) int {
+	count := 0
+	for _, record := range n.names {
+		if record.Identity == identity && !record.Reserved {
+			count++
+		}
+	}
+	return count
+}
+
+// Reserve reserves label of identity, so other identities can't claim names in its namespace. Names never
+// change owner, when other identities claimed names in the namespace before, nothing is reserved and the names
+// are returned, an administrator may release them. Labels of keys look like fingerprints, they are never names
+// and don't need reservation
+func (n *NameRegistry) Reserve(identity string) ([]string, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	label := common.IdentityLabel(identity)
+	if record, ok := n.names[label]; ok && record.Reserved && record.Identity == identity {
+		return nil, nil
+	}
+
+	var conflicts []string
+	for name, record := range n.names {
+		if record.Identity != identity && (name == label || strings.HasSuffix(name, "-"+label)) {
+			conflicts = append(conflicts, name)
+		}
+	}
+	if len(conflicts) != 0 {
+		sort.Strings(conflicts)
+		return conflicts, nil
+	}
+
+	n.names[label] = &NameRecord{Identity: identity, Created: time.Now().UTC(), Reserved: true}
+	if err := n.save(); err != nil {
+		delete(n.names, label)
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (n *NameRegistry) Release(name string) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	record, ok := n.names[name]
+	if !ok {
+		return common.ErrNameNotFound
+	}
+
+	delete(n.names, name)
+	if err := n.save(); err != nil {
+		n.names[name] = record
+		return err
+	}
+	return nil
+}
+
+func (n *NameRegistry) Names() map[string]NameRecord {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	names := make(map[string]NameRecord, len(n.names))
+	for name, record := range n.names {
+		names[name] = *record
+	}
+	return names
+}
+
+// NewNameRegistry loads registry from path, missing file is created on the first claim
+func NewNameRegistry(path string, maxNames int) (*NameRegistry, error) {
+	registry := &NameRegistry{
+		path:     path,
+		maxNames: maxNames,
+		names:    make(map[string]*NameRecord),
+	}
+	if err := registry.load(); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
@@ -0,0 +1,139 @@
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"r-ssh/common"
+	"testing"
+)
+
+func TestNameRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "names.json")
+
+	registry, err := NewNameRegistry(path, 0)
+	if err != nil {
+		t.Fatalf("NewNameRegistry() error: %s", err)
+	}
+
+	if err = registry.Claim("myapp", "alice"); err != nil {
+		t.Fatalf("Claim() error: %s", err)
+	}
+	if err = registry.Claim("myapp", "alice"); err != nil {
+		t.Errorf("Claim() by owner error: %s", err)
+	}
+	if err = registry.Claim("myapp", "bob"); err != common.ErrNameTaken {
+		t.Errorf("Claim() by other identity = %v, want %v", err, common.ErrNameTaken)
+	}
+
+	registry, err = NewNameRegistry(path, 0)
+	if err != nil {
+		t.Fatalf("NewNameRegistry() reload error: %s", err)
+	}
+	if owner, ok := registry.Owner("myapp"); !ok || owner != "alice" {
+		t.Errorf("Owner() = %s, %v, want alice, true", owner, ok)
+	}
+
+	if err = registry.Release("myapp"); err != nil {
+		t.Fatalf("Release() error: %s", err)
+	}
+	if err = registry.Release("myapp"); err != common.ErrNameNotFound {
+		t.Errorf("Release() = %v, want %v", err, common.ErrNameNotFound)
+	}
+	if err = registry.Claim("myapp", "bob"); err != nil {
+		t.Errorf("Claim() after release error: %s", err)
+	}
+
+	registry, err = NewNameRegistry(path, 0)
+	if err != nil {
+		t.Fatalf("NewNameRegistry() reload error: %s", err)
+	}
+	if names := registry.Names(); len(names) != 1 || names["myapp"].Identity != "bob" {
+		t.Errorf("Names() = %v", names)
+	}
+}
+
+func TestNameRegistry_Reserve(t *testing.T) {
+	registry, err := NewNameRegistry(filepath.Join(t.TempDir(), "names.json"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conflicts, err := registry.Reserve("cert:alice"); err != nil || len(conflicts) != 0 {
+		t.Fatalf("Reserve() = %v, %v", conflicts, err)
+	}
+	if owner, _ := registry.Owner("cert_alice"); owner != "cert:alice" {
+		t.Errorf("Owner() = %s, want cert:alice", owner)
+	}
+
+	for _, name := range []string{"cert_alice", "8080-cert_alice", "api-cert_alice"} {
+		if err = registry.Claim(name, "cert:mallory"); err != common.ErrNameTaken {
+			t.Errorf("Claim(%s) in reserved namespace = %v, want %v", name, err, common.ErrNameTaken)
+		}
+	}
+	if err = registry.Claim("api-cert_alice", "cert:alice"); err != nil {
+		t.Errorf("Claim() in own namespace error: %s", err)
+	}
+	if err = registry.Claim("cert_alicex", "cert:mallory"); err != nil {
+		t.Errorf("Claim() outside of namespace error: %s", err)
+	}
+
+	// equal label of other source has its own namespace
+	if conflicts, err := registry.Reserve("pw:alice"); err != nil || len(conflicts) != 0 {
+		t.Errorf("Reserve() of other source = %v, %v", conflicts, err)
+	}
+	if owner, _ := registry.Owner("pw_alice"); owner != "pw:alice" {
+		t.Errorf("Owner() = %s, want pw:alice", owner)
+	}
+	if owner, _ := registry.Owner("api-cert_alice"); owner != "cert:alice" {
+		t.Errorf("Owner() = %s, want cert:alice", owner)
+	}
+}
+
+func TestNameRegistry_ReserveKeepsClaimedNames(t *testing.T) {
+	registry, err := NewNameRegistry(filepath.Join(t.TempDir(), "names.json"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"pw_bob", "api-pw_bob"} {
+		if err = registry.Claim(name, "cert:mallory"); err != nil {
+			t.Fatalf("Claim(%s) error: %s", name, err)
+		}
+	}
+	if err = registry.Claim("third", "cert:mallory"); err != common.ErrTooManyNames {
+		t.Errorf("Claim() over limit = %v, want %v", err, common.ErrTooManyNames)
+	}
+
+	// new identity whose label matches names claimed before doesn't take them over
+	conflicts, err := registry.Reserve("pw:bob")
+	if err != nil {
+		t.Fatalf("Reserve() error: %s", err)
+	}
+	if len(conflicts) != 2 || conflicts[0] != "api-pw_bob" || conflicts[1] != "pw_bob" {
+		t.Errorf("Reserve() conflicts = %v, want [api-pw_bob pw_bob]", conflicts)
+	}
+	for _, name := range []string{"pw_bob", "api-pw_bob"} {
+		if owner, _ := registry.Owner(name); owner != "cert:mallory" {
+			t.Errorf("Owner(%s) = %s, want cert:mallory", name, owner)
+		}
+	}
+
+	// once an administrator releases the names, the label is reserved on the next connection
+	for _, name := range []string{"pw_bob", "api-pw_bob"} {
+		if err = registry.Release(name); err != nil {
+			t.Fatalf("Release(%s) error: %s", name, err)
+		}
+	}
+	if conflicts, err = registry.Reserve("pw:bob"); err != nil || len(conflicts) != 0 {
+		t.Fatalf("Reserve() = %v, %v", conflicts, err)
+	}
+	if owner, _ := registry.Owner("pw_bob"); owner != "pw:bob" {
+		t.Errorf("Owner() = %s, want pw:bob", owner)
+	}
+}
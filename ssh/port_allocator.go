@@ -0,0 +1,53 @@
+package ssh
+
+import (
+	"r-ssh/common"
+	"sync"
+)
+
+// PortAllocator hands out public ports for tcp forwards from inclusive range.
+// Ports are allocated round robin, so just released port isn't reused by the next forward.
+type PortAllocator struct {
+	min, max int
+
+	lock sync.Mutex
+	used map[int]struct{}
+	next int
+}
+
+func (p *PortAllocator) Size() int {
+	return p.max - p.min + 1
+}
+
+func (p *PortAllocator) Allocate() (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for i := 0; i < p.Size(); i++ {
+		port := p.min + (p.next-p.min+i)%p.Size()
+		if _, ok := p.used[port]; ok {
+			continue
+		}
+
+		p.used[port] = struct{}{}
+		p.next = port + 1
+		return port, nil
+	}
+	return 0, common.ErrNoFreePort
+}
+
+func (p *PortAllocator) Release(port int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.used, port)
+}
+
+func NewPortAllocator(min, max int) *PortAllocator {
+	return &PortAllocator{
+		min:  min,
+		max:  max,
+		used: make(map[int]struct{}),
+		next: min,
+	}
+}
@@ -0,0 +1,30 @@
+package ssh
+
+import (
+	"r-ssh/common"
+	"testing"
+)
+
+func TestPortAllocator(t *testing.T) {
+	ports := NewPortAllocator(1000, 1002)
+
+	for _, want := range []int{1000, 1001, 1002} {
+		if port, err := ports.Allocate(); err != nil || port != want {
+			t.Fatalf("Allocate() = %d, %v, want %d", port, err, want)
+		}
+	}
+	if _, err := ports.Allocate(); err != common.ErrNoFreePort {
+		t.Fatalf("Allocate() error = %v, want %v", err, common.ErrNoFreePort)
+	}
+
+	ports.Release(1001)
+	if port, err := ports.Allocate(); err != nil || port != 1001 {
+		t.Fatalf("Allocate() = %d, %v, want 1001", port, err)
+	}
+
+	ports.Release(1000)
+	ports.Release(1002)
+	if port, _ := ports.Allocate(); port != 1002 {
+		t.Errorf("Allocate() = %d, want round robin 1002", port)
+	}
+}
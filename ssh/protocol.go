@@ -18,3 +18,15 @@ type remoteForwardData struct {
 }
 
 const forwardedChannelType = "forwarded-tcpip"
+
+// Source: https://github.com/openssh/openssh-portable/blob/master/PROTOCOL section 2.4
+type streamLocalForwardRequest struct {
+	SocketPath string
+}
+
+type forwardedStreamLocalData struct {
+	SocketPath string
+	Reserved   string
+}
+
+const forwardedStreamLocalChannelType = "forwarded-streamlocal@openssh.com"
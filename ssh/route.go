@@ -0,0 +1,113 @@
+package ssh
+
+import (
+	"r-ssh/common"
+	"strings"
+)
+
+type routeMember struct {
+	id      string
+	conn    *ConnectionWrapper
+	handler ForwardHandler
+	info    *common.ForwardInfo
+	// active is number of requests in progress, it's guarded by ForwardController.redirectLock
+	active int
+}
+
+// route is subdomain served by single forward or by pool of forwards, pools of names may be shared by identities
+// whose policies allow it
+type route struct {
+	members []*routeMember
+	next    int
+}
+
+func (r *route) add(member *routeMember) error {
+	if len(r.members) == 0 {
+		r.members = append(r.members, member)
+		return nil
+	}
+
+	first := r.members[0]
+	// members must be interchangeable, so they share identity (or pool), flags and hosts
+	if !first.info.Pooled() || first.info.Flags() != member.info.Flags() ||
+		strings.Join(first.info.Hosts, ",") != strings.Join(member.info.Hosts, ",") {
+		return common.ErrForwardAlreadyBinded
+	}
+	if first.conn.Identity != member.conn.Identity && !sharedPool(first, member) {
+		return common.ErrForwardAlreadyBinded
+	}
+	r.members = append(r.members, member)
+	return nil
+}
+
+// sharedPool reports whether policies of both members allow sharing pool of the name
+func sharedPool(first, member *routeMember) bool {
+	name := member.info.Name
+	return name != "" && first.conn.Policy.CheckPool(name) == nil && member.conn.Policy.CheckPool(name) == nil
+}
+
+// remove deletes member of connection and returns false if connection wasn't a member
+func (r *route) remove(conn *ConnectionWrapper) bool {
+	for i, member := range r.members {
+		if member.conn == conn {
+			r.members = append(r.members[:i], r.members[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (r *route) member(conn *ConnectionWrapper) *routeMember {
+	for _, member := range r.members {
+		if member.conn == conn {
+			return member
+		}
+	}
+	return nil
+}
+
+// pick selects member for request, stickyID is the member id remembered by the client
+func (r *route) pick(stickyID string) *routeMember {
+	info := r.members[0].info
+	if info.Sticky && stickyID != "" {
+		for _, member := range r.members {
+			if member.id == stickyID {
+				return member
+			}
+		}
+	}
+
+	// ties of least connections are resolved round robin
+	start := r.next % len(r.members)
+	r.next = start + 1
+
+	picked := r.members[start]
+	if info.LeastConnections {
+		for i := 1; i < len(r.members); i++ {
+			member := r.members[(start+i)%len(r.members)]
+			if member.active < picked.active {
+				picked = member
+			}
+		}
+	}
+	return picked
+}
+
+// Forward is route member selected for single request, Release must be called when the request is done
+type Forward struct {
+	Handler ForwardHandler
+	Info    *common.ForwardInfo
+	// MemberID should be remembered by the client (e.g. in cookie) when Info.Sticky is set
+	MemberID string
+	// Identity owns the selected member
+	Identity string
+
+	release func()
+}
+
+// Release is no-op for forwards not acquired from ForwardController
+func (f *Forward) Release() {
+	if f.release != nil {
+		f.release()
+	}
+}
@@ -0,0 +1,112 @@
+package ssh
+
+import (
+	"r-ssh/common"
+	"r-ssh/ssh/auth"
+	"testing"
+)
+
+func newRoute(t *testing.T, address string, members ...*ConnectionWrapper) *route {
+	r := &route{}
+	for i, conn := range members {
+		err := r.add(&routeMember{id: string(rune('a' + i)), conn: conn, info: common.BuildForwardInfo(conn.Identity, address, 80)})
+		if err != nil {
+			t.Fatalf("add() error: %s", err)
+		}
+	}
+	return r
+}
+
+func TestRoute_add(t *testing.T) {
+	alice1 := &ConnectionWrapper{Identity: "alice"}
+	alice2 := &ConnectionWrapper{Identity: "alice"}
+	bob := &ConnectionWrapper{Identity: "bob"}
+
+	tests := []struct {
+		name    string
+		first   string
+		conn    *ConnectionWrapper
+		address string
+		want    error
+	}{
+		{name: "Pool", first: "app+r", conn: alice2, address: "app+r", want: nil},
+		{name: "Not pooled", first: "app", conn: alice2, address: "app", want: common.ErrForwardAlreadyBinded},
+		{name: "Join not pooled", first: "app", conn: alice2, address: "app+r", want: common.ErrForwardAlreadyBinded},
+		{name: "Other mode", first: "app+r", conn: alice2, address: "app+l", want: common.ErrForwardAlreadyBinded},
+		{name: "Other flags", first: "app+r", conn: alice2, address: "app+rs", want: common.ErrForwardAlreadyBinded},
+		{name: "Other identity", first: "app+r", conn: bob, address: "app+r", want: common.ErrForwardAlreadyBinded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRoute(t, tt.first, alice1)
+			member := &routeMember{conn: tt.conn, info: common.BuildForwardInfo(tt.conn.Identity, tt.address, 80)}
+			if err := r.add(member); err != tt.want {
+				t.Errorf("add() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoute_addSharedPool(t *testing.T) {
+	team := &auth.Policy{AllowedPools: []string{"app"}}
+	alice := &ConnectionWrapper{Identity: "cert:alice", Policy: team}
+	bob := &ConnectionWrapper{Identity: "cert:bob", Policy: team}
+	mallory := &ConnectionWrapper{Identity: "cert:mallory"}
+
+	tests := []struct {
+		name    string
+		conn    *ConnectionWrapper
+		address string
+		want    error
+	}{
+		{name: "Allowed", conn: bob, address: "app+r", want: nil},
+		{name: "Not allowed", conn: mallory, address: "app+r", want: common.ErrForwardAlreadyBinded},
+		{name: "Not pooled", conn: bob, address: "app", want: common.ErrForwardAlreadyBinded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &route{}
+			if err := r.add(&routeMember{conn: alice, info: common.BuildNamedForwardInfo("app+r", 80)}); err != nil {
+				t.Fatalf("add() error: %s", err)
+			}
+			if err := r.add(&routeMember{conn: tt.conn, info: common.BuildNamedForwardInfo(tt.address, 80)}); err != tt.want {
+				t.Errorf("add() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoute_pick(t *testing.T) {
+	conn1 := &ConnectionWrapper{Identity: "alice"}
+	conn2 := &ConnectionWrapper{Identity: "alice"}
+	conn3 := &ConnectionWrapper{Identity: "alice"}
+
+	roundRobin := newRoute(t, "app+r", conn1, conn2, conn3)
+	got := ""
+	for i := 0; i < 4; i++ {
+		got += roundRobin.pick("").id
+	}
+	if got != "abca" {
+		t.Errorf("round robin pick() = %s, want abca", got)
+	}
+
+	leastConnections := newRoute(t, "app+l", conn1, conn2, conn3)
+	leastConnections.members[0].active = 2
+	leastConnections.members[1].active = 1
+	if member := leastConnections.pick(""); member.id != "c" {
+		t.Errorf("least connections pick() = %s, want c", member.id)
+	}
+
+	sticky := newRoute(t, "app+rc", conn1, conn2, conn3)
+	for i := 0; i < 3; i++ {
+		if member := sticky.pick("b"); member.id != "b" {
+			t.Errorf("sticky pick() = %s, want b", member.id)
+		}
+	}
+	if !sticky.remove(conn2) || sticky.remove(conn2) {
+		t.Error("remove() must remove member only once")
+	}
+	if member := sticky.pick("b"); member.id == "b" {
+		t.Error("sticky pick() returned removed member")
+	}
+}
@@ -1,24 +1,88 @@
 package ssh
 
 import (
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ssh"
 	"io"
 	"net"
 	"r-ssh/common"
+	"r-ssh/ssh/audit"
 	"r-ssh/ssh/auth"
 	"r-ssh/ssh/host_key"
 	"r-ssh/ssh/terminal"
+	"strconv"
+	"sync"
+	"time"
 )
 
+// handshakeTimeout limits time the client has to complete authentication
+const handshakeTimeout = 30 * time.Second
+
+const (
+	methodPublicKey           = "publickey"
+	methodPassword            = "password"
+	methodKeyboardInteractive = "keyboard-interactive"
+)
+
+type ServerOptions struct {
+	FingerprintScheme common.FingerprintScheme
+	// LegacySubdomains keeps md5 based subdomains reachable while migrating to another fingerprint scheme
+	LegacySubdomains bool
+	// PasswordProvider enables password and keyboard-interactive authentication
+	PasswordProvider auth.PasswordProvider
+	// RevocationList is checked before auth provider
+	RevocationList *auth.RevocationList
+	// BanList rejects ips which failed authentication too often, nil disables banning
+	BanList *BanList
+	// MaxAuthTries limits auth attempts per connection, 0 keeps ssh library default
+	MaxAuthTries int
+	// AuditLog receives security events, nil disables audit
+	AuditLog *audit.Logger
+	// NameRegistry enables forwards to reserved names, e.g. "ssh -R myapp:80:localhost:3000"
+	NameRegistry *NameRegistry
+	// DomainRegistry enables forwards to custom domains, e.g. "ssh -R demo.customer.com+d:80:localhost:3000"
+	DomainRegistry *DomainRegistry
+	// ExtraHosts serve the same tunnels under more base domains besides the host
+	ExtraHosts []string
+	// HostPolicies restrict forwards published under the base domain, the session policy applies as well
+	HostPolicies map[string]*auth.Policy
+	// TCPPorts are allocated for raw tcp forwards, nil disables tcp forwards
+	TCPPorts *PortAllocator
+	// TCPListenAddr is ip address tcp forwards listen on
+	TCPListenAddr string
+	// TCPMaxPorts limits tcp ports allocated to sessions of single identity, 0 is unlimited
+	TCPMaxPorts int
+	// SessionTakeover lets new session of the same identity take subdomains of the old one, the old session is closed
+	SessionTakeover bool
+	// DirectTCPIP lets clients reach forwards with direct-tcpip channels, e.g. "ssh -J <host> <subdomain>"
+	DirectTCPIP bool
+}
+
+type pendingGrant struct {
+	grant     *auth.Grant
+	publicKey ssh.PublicKey
+	method    string
+}
+
 type Server struct {
 	config   *ssh.ServerConfig
 	endpoint string
 	provider auth.Provider
 	host     string
+	options  ServerOptions
 
 	requestHandlers map[string]Controller
 
+	// grants are kept between auth callback and handshake completion, keyed by remote address and grant id.
+	// Callbacks may accept several keys (e.g. key queries) before one is used, so the id is passed in permissions.
+	grantsLock sync.Mutex
+	grantSeq   uint64
+	grants     map[string]map[string]*pendingGrant
+
+	connectionsLock sync.Mutex
+	connections     map[*ConnectionWrapper]struct{}
+
 	forwardController *ForwardController
 }
 
@@ -26,19 +90,127 @@ func (s *Server) ForwardController() *ForwardController {
 	return s.forwardController
 }
 
-func (s *Server) publicKeyCallback(_ ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
-	fingerprint := common.GetFingerprint(pubKey)
-	if !s.provider.Auth(fingerprint) {
-		return nil, common.ErrAuthNotAllowed
+func (s *Server) BanList() *BanList {
+	return s.options.BanList
+}
+
+func (s *Server) DomainRegistry() *DomainRegistry {
+	return s.options.DomainRegistry
+}
+
+func (s *Server) NameRegistry() *NameRegistry {
+	return s.options.NameRegistry
+}
+
+// Hosts returns base domains of tunnels, the primary host is the first
+func (s *Server) Hosts() []string {
+	return s.forwardController.hosts
+}
+
+// auditAuthDenied logs rejected key or password. Accepted keys are logged once the handshake proves
+// the client owns them, key queries are accepted without signature
+func (s *Server) auditAuthDenied(conn ssh.ConnMetadata, method, fingerprint string, err error) {
+	event := connectionEvent(audit.EventAuth, conn)
+	event.Method = method
+	event.Fingerprint = fingerprint
+	event.Result = audit.ResultDenied
+	event.Error = err.Error()
+	s.options.AuditLog.Log(event)
+}
+
+func (s *Server) publicKeyCallback(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	fingerprint := s.options.FingerprintScheme.Fingerprint(pubKey)
+	if s.options.RevocationList != nil && s.options.RevocationList.IsRevoked(pubKey) {
+		s.auditAuthDenied(conn, methodPublicKey, fingerprint, common.ErrKeyRevoked)
+		return nil, common.ErrKeyRevoked
+	}
+
+	req := &auth.Request{
+		User:        conn.User(),
+		RemoteAddr:  conn.RemoteAddr(),
+		PublicKey:   pubKey,
+		Fingerprint: fingerprint,
+	}
+	grant, err := s.provider.Auth(req)
+	if err != nil {
+		s.auditAuthDenied(conn, methodPublicKey, fingerprint, err)
+		return nil, err
+	}
+
+	pending := &pendingGrant{grant: grant, publicKey: pubKey, method: methodPublicKey}
+	extensions := map[string]string{
+		common.ExtensionFingerprint: fingerprint,
+		common.ExtensionGrant:       s.storeGrant(conn.RemoteAddr(), pending),
+	}
+	if s.options.LegacySubdomains && grant.Identity == common.KeyIdentity(fingerprint) {
+		extensions[common.ExtensionLegacyFingerprint] = common.GetFingerprint(pubKey)
+	}
+	return &ssh.Permissions{Extensions: extensions}, nil
+}
+
+func (s *Server) authPassword(conn ssh.ConnMetadata, method string, password []byte) (*ssh.Permissions, error) {
+	req := &auth.Request{
+		User:       conn.User(),
+		RemoteAddr: conn.RemoteAddr(),
+	}
+	grant, err := s.options.PasswordProvider.AuthPassword(req, password)
+	if err != nil {
+		s.auditAuthDenied(conn, method, "", err)
+		return nil, err
 	}
 
 	return &ssh.Permissions{
 		Extensions: map[string]string{
-			common.ExtensionFingerprint: fingerprint,
+			common.ExtensionGrant: s.storeGrant(conn.RemoteAddr(), &pendingGrant{grant: grant, method: method}),
 		},
 	}, nil
 }
 
+func (s *Server) passwordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	return s.authPassword(conn, methodPassword, password)
+}
+
+func (s *Server) keyboardInteractiveCallback(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	answers, err := client("", "", []string{"Password: "}, []bool{false})
+	if err != nil {
+		return nil, err
+	}
+	if len(answers) != 1 {
+		return nil, common.ErrAuthNotAllowed
+	}
+	return s.authPassword(conn, methodKeyboardInteractive, []byte(answers[0]))
+}
+
+func (s *Server) storeGrant(remoteAddr net.Addr, grant *pendingGrant) string {
+	s.grantsLock.Lock()
+	defer s.grantsLock.Unlock()
+
+	s.grantSeq++
+	id := strconv.FormatUint(s.grantSeq, 10)
+
+	grants, ok := s.grants[remoteAddr.String()]
+	if !ok {
+		grants = make(map[string]*pendingGrant)
+		s.grants[remoteAddr.String()] = grants
+	}
+	grants[id] = grant
+	return id
+}
+
+// takeGrant returns grant used for authentication and forgets all grants of the connection
+func (s *Server) takeGrant(remoteAddr net.Addr, permissions *ssh.Permissions) *pendingGrant {
+	s.grantsLock.Lock()
+	defer s.grantsLock.Unlock()
+
+	grants := s.grants[remoteAddr.String()]
+	delete(s.grants, remoteAddr.String())
+
+	if permissions == nil {
+		return nil
+	}
+	return grants[permissions.Extensions[common.ExtensionGrant]]
+}
+
 func (s *Server) authLogCallback(conn ssh.ConnMetadata, method string, err error) {
 	connectionLog := common.NewConnectionLog(conn).WithField("method", method)
 	if err == nil {
@@ -46,12 +218,55 @@ func (s *Server) authLogCallback(conn ssh.ConnMetadata, method string, err error
 	} else {
 		connectionLog.WithError(err).Warnln("auth failed")
 	}
+
+	if s.options.BanList == nil {
+		return
+	}
+
+	// rejected keys are not counted, clients offer every key they have and key queries are reported
+	// the same way as signed attempts. Signatures can't be guessed, so only failed passwords are counted
+	switch {
+	case err == nil:
+		s.options.BanList.Success(common.AddrIP(conn.RemoteAddr()))
+	case method == methodPassword || method == methodKeyboardInteractive:
+		s.authFailure(conn.RemoteAddr())
+	}
+}
+
+func (s *Server) authFailure(remoteAddr net.Addr) {
+	if s.options.BanList == nil || !s.options.BanList.Failure(common.AddrIP(remoteAddr)) {
+		return
+	}
+
+	log.WithField("remote-addr", remoteAddr.String()).Warnln("remote ip banned")
+	s.options.AuditLog.Log(&audit.Event{
+		Type:       audit.EventBan,
+		RemoteAddr: remoteAddr.String(),
+		Reason:     "too many auth failures",
+	})
 }
 
 func (s *Server) bannerCallback(ssh.ConnMetadata) string {
 	return common.BannerMessage
 }
 
+func (s *Server) handleChannels(connection *ConnectionWrapper, channels <-chan ssh.NewChannel) {
+	logger := common.NewConnectionLog(connection.Connection)
+	for newChannel := range channels {
+		switch newChannel.ChannelType() {
+		case "session":
+			connection.Terminal.HandleChannel(newChannel)
+		case "direct-tcpip":
+			go s.forwardController.HandleDirectTCPIP(connection, newChannel)
+		default:
+			err := newChannel.Reject(ssh.UnknownChannelType, "not supported")
+			if err != nil {
+				logger.WithError(err).Warnln("reject channel failed")
+			}
+		}
+	}
+}
+
 func (s *Server) handleRequests(connection *ConnectionWrapper, reqs <-chan *ssh.Request) {
 	logger := common.NewConnectionLog(connection.Connection)
 	for req := range reqs {
@@ -82,18 +297,154 @@ func (s *Server) handleRequests(connection *ConnectionWrapper, reqs <-chan *ssh.
 	}
 }
 
+// reserveIdentity reserves subdomains derived from identity which isn't a key in name registry
+func (s *Server) reserveIdentity(wrapper *ConnectionWrapper) {
+	if s.options.NameRegistry == nil || common.IdentitySource(wrapper.Identity) == common.IdentityKey {
+		return
+	}
+
+	logger := common.NewConnectionLog(wrapper.Connection)
+	conflicts, err := s.options.NameRegistry.Reserve(wrapper.Identity)
+	if err != nil {
+		logger.WithError(err).Warnln("reserve identity failed")
+	}
+	if len(conflicts) != 0 {
+		logger.WithField("names", conflicts).Warnln("identity not reserved, names of other identities have to be released first")
+	}
+}
+
 func (s *Server) cleanup(wrapper *ConnectionWrapper) {
 	logger := common.NewConnectionLog(wrapper.Connection)
 
 	err := wrapper.Connection.Wait()
-	if err != nil && err != io.EOF {
+	if err == io.EOF {
+		err = nil
+	}
+	if err != nil {
 		logger.WithError(err).Warnln("connection closed with error")
 	}
 
+	event := wrapper.auditEvent(audit.EventDisconnect)
+	event.Reason = wrapper.disconnectReason(err)
+
+	s.removeConnection(wrapper)
+
 	err = s.forwardController.Shutdown(wrapper)
 	if err != nil {
 		logger.WithError(err).Warnln("shutdown forward failed")
 	}
+
+	s.options.AuditLog.Log(event)
+}
+
+func (s *Server) addConnection(wrapper *ConnectionWrapper) {
+	s.connectionsLock.Lock()
+	defer s.connectionsLock.Unlock()
+
+	s.connections[wrapper] = struct{}{}
+}
+
+func (s *Server) removeConnection(wrapper *ConnectionWrapper) {
+	s.connectionsLock.Lock()
+	defer s.connectionsLock.Unlock()
+
+	delete(s.connections, wrapper)
+}
+
+func (s *Server) liveConnections() []*ConnectionWrapper {
+	s.connectionsLock.Lock()
+	defer s.connectionsLock.Unlock()
+
+	connections := make([]*ConnectionWrapper, 0, len(s.connections))
+	for wrapper := range s.connections {
+		connections = append(connections, wrapper)
+	}
+	return connections
+}
+
+// SessionCount returns number of connected clients
+func (s *Server) SessionCount() int {
+	s.connectionsLock.Lock()
+	defer s.connectionsLock.Unlock()
+	return len(s.connections)
+}
+
+// DisconnectRevoked closes live connections authenticated with revoked keys, their forwards are removed on cleanup
+func (s *Server) DisconnectRevoked() {
+	if s.options.RevocationList == nil {
+		return
+	}
+
+	for _, wrapper := range s.liveConnections() {
+		if wrapper.PublicKey == nil || !s.options.RevocationList.IsRevoked(wrapper.PublicKey) {
+			continue
+		}
+
+		common.NewConnectionLog(wrapper.Connection).WithField("fingerprint", wrapper.Fingerprint).Warnln("disconnect revoked key")
+		_, _ = wrapper.Terminal.WriteString(fmt.Sprintf("%s\r\n", common.ErrKeyRevoked))
+		_ = wrapper.CloseWithReason(common.ErrKeyRevoked.Error())
+	}
+}
+
+func (s *Server) auditDisconnect(remoteAddr net.Addr, reason string) {
+	s.options.AuditLog.Log(&audit.Event{
+		Type:       audit.EventDisconnect,
+		RemoteAddr: remoteAddr.String(),
+		Reason:     reason,
+	})
+}
+
+func (s *Server) handleConnection(tcpConn net.Conn) {
+	_ = tcpConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	connection, channels, reqs, err := ssh.NewServerConn(tcpConn, s.config)
+	if err != nil {
+		s.takeGrant(tcpConn.RemoteAddr(), nil)
+		_ = tcpConn.Close()
+		log.WithError(err).Warnln("handshake failed")
+		s.auditDisconnect(tcpConn.RemoteAddr(), err.Error())
+		return
+	}
+	_ = tcpConn.SetDeadline(time.Time{})
+
+	pending := s.takeGrant(tcpConn.RemoteAddr(), connection.Permissions)
+	if pending == nil {
+		log.Warnln("grant not found")
+		_ = connection.Close()
+		s.auditDisconnect(tcpConn.RemoteAddr(), "grant not found")
+		return
+	}
+
+	t := terminal.NewBasicTerminal(connection)
+	wrapper := &ConnectionWrapper{
+		Connection:        connection,
+		Fingerprint:       connection.Permissions.Extensions[common.ExtensionFingerprint],
+		PublicKey:         pending.publicKey,
+		Identity:          pending.grant.Identity,
+		LegacyFingerprint: connection.Permissions.Extensions[common.ExtensionLegacyFingerprint],
+		Terminal:          t,
+		Policy:            pending.grant.Policy,
+		DisplayName:       pending.grant.DisplayName,
+		Metadata:          pending.grant.Metadata,
+	}
+	s.addConnection(wrapper)
+	s.reserveIdentity(wrapper)
+
+	event := wrapper.auditEvent(audit.EventAuth)
+	event.Method = pending.method
+	event.Result = audit.ResultAllowed
+	s.options.AuditLog.Log(event)
+
+	event = wrapper.auditEvent(audit.EventSession)
+	event.Method = pending.method
+	s.options.AuditLog.Log(event)
+
+	if wrapper.DisplayName != "" {
+		_, _ = t.WriteString(fmt.Sprintf("authenticated as \"%s\"\r\n", wrapper.DisplayName))
+	}
+
+	go s.handleChannels(wrapper, channels)
+	go s.handleRequests(wrapper, reqs)
+	go s.cleanup(wrapper)
 }
 
 func (s *Server) Listen() error {
@@ -109,47 +460,54 @@ func (s *Server) Listen() error {
 			continue
 		}
 
-		connection, channels, reqs, err := ssh.NewServerConn(tcpConn, s.config)
-		if err != nil {
-			log.WithError(err).Warnln("handshake failed")
-			continue
-		}
+		s.options.AuditLog.Log(&audit.Event{
+			Type:       audit.EventConnect,
+			RemoteAddr: tcpConn.RemoteAddr().String(),
+		})
 
-		t := terminal.NewBasicTerminal(connection)
-		wrapper := &ConnectionWrapper{
-			Connection:  connection,
-			Fingerprint: connection.Permissions.Extensions[common.ExtensionFingerprint],
-			Terminal:    t,
+		if s.options.BanList != nil && s.options.BanList.IsBanned(common.AddrIP(tcpConn.RemoteAddr())) {
+			log.WithField("remote-addr", tcpConn.RemoteAddr().String()).Debugln("banned ip rejected")
+			_ = tcpConn.Close()
+			s.auditDisconnect(tcpConn.RemoteAddr(), "ip banned")
+			continue
 		}
 
-		go t.HandleChannels(channels)
-		go s.handleRequests(wrapper, reqs)
-		go s.cleanup(wrapper)
+		go s.handleConnection(tcpConn)
 	}
 }
 
-func NewServer(endpoint, host, hostKey string, provider auth.Provider) (*Server, error) {
+func NewServer(endpoint, host, hostKey string, provider auth.Provider, options ServerOptions) (*Server, error) {
 	key, err := host_key.LoadOrGenerateHostKey(hostKey)
 	if err != nil {
 		return nil, err
 	}
 
-	forwardController := NewForwardController(host)
+	forwardController := NewForwardController(host, options)
 
 	server := Server{
 		endpoint:          endpoint,
 		provider:          provider,
 		host:              host,
+		options:           options,
 		forwardController: forwardController,
+		grants:            make(map[string]map[string]*pendingGrant),
+		connections:       make(map[*ConnectionWrapper]struct{}),
 		requestHandlers: map[string]Controller{
-			"tcpip-forward":        forwardController,
-			"cancel-tcpip-forward": forwardController,
+			"tcpip-forward":                          forwardController,
+			"cancel-tcpip-forward":                   forwardController,
+			"streamlocal-forward@openssh.com":        forwardController,
+			"cancel-streamlocal-forward@openssh.com": forwardController,
 		},
 	}
 	server.config = &ssh.ServerConfig{
 		PublicKeyCallback: server.publicKeyCallback,
 		AuthLogCallback:   server.authLogCallback,
 		BannerCallback:    server.bannerCallback,
+		MaxAuthTries:      options.MaxAuthTries,
+	}
+	if options.PasswordProvider != nil {
+		server.config.PasswordCallback = server.passwordCallback
+		server.config.KeyboardInteractiveCallback = server.keyboardInteractiveCallback
 	}
 	server.config.AddHostKey(key)
 	return &server, nil
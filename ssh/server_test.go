@@ -0,0 +1,167 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"r-ssh/common"
+	"r-ssh/ssh/audit"
+	"r-ssh/ssh/auth"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestServer(t *testing.T, provider auth.Provider, options ServerOptions) *Server {
+	server, err := NewServer("127.0.0.1:0", "example.com", filepath.Join(t.TempDir(), "host_key"), provider, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// forgedSigner offers public key it can't sign for
+type forgedSigner struct {
+	ssh.Signer
+	publicKey ssh.PublicKey
+}
+
+func (f *forgedSigner) PublicKey() ssh.PublicKey {
+	return f.publicKey
+}
+
+// connect runs single connection of the server, it returns after the server is done with the handshake
+func connect(t *testing.T, server *Server, methods ...ssh.AuthMethod) (*ssh.Client, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if conn, err := listener.Accept(); err == nil {
+			server.handleConnection(conn)
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		Auth:            methods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	<-done
+	return client, err
+}
+
+type passwordFunc func(req *auth.Request, password []byte) (*auth.Grant, error)
+
+func (f passwordFunc) AuthPassword(req *auth.Request, password []byte) (*auth.Grant, error) {
+	return f(req, password)
+}
+
+func TestServer_banCountsPasswords(t *testing.T) {
+	localhost := net.ParseIP("127.0.0.1")
+
+	rejectKeys := auth.NewWhitelistAuthProvider(nil)
+	server := newTestServer(t, rejectKeys, ServerOptions{
+		BanList: NewBanList(2, time.Minute, time.Minute),
+		PasswordProvider: passwordFunc(func(*auth.Request, []byte) (*auth.Grant, error) {
+			return nil, common.ErrAuthNotAllowed
+		}),
+	})
+	keys := ssh.PublicKeys(newTestSigner(t), newTestSigner(t), newTestSigner(t))
+	for i := 0; i < 3; i++ {
+		if _, err := connect(t, server, keys); err == nil {
+			t.Fatal("connect() must fail with rejected keys")
+		}
+	}
+	if server.BanList().IsBanned(localhost) {
+		t.Fatal("rejected keys must not ban the client")
+	}
+
+	if _, err := connect(t, server, ssh.Password("wrong")); err == nil {
+		t.Fatal("connect() must fail with wrong password")
+	}
+	if _, err := connect(t, server, ssh.Password("wrong")); err == nil {
+		t.Fatal("connect() must fail with wrong password")
+	}
+	if !server.BanList().IsBanned(localhost) {
+		t.Error("failed passwords must ban the client")
+	}
+}
+
+// auditBuffer collects audit events written by connection goroutines
+type auditBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (a *auditBuffer) Write(p []byte) (int, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.buffer.Write(p)
+}
+
+func (a *auditBuffer) events(t *testing.T, eventType audit.EventType) []*audit.Event {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	var events []*audit.Event
+	decoder := json.NewDecoder(bytes.NewReader(a.buffer.Bytes()))
+	for decoder.More() {
+		event := &audit.Event{}
+		if err := decoder.Decode(event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestServer_auditAuth(t *testing.T) {
+	events := &auditBuffer{}
+	server := newTestServer(t, auth.DefaultAuthProvider, ServerOptions{AuditLog: audit.NewLogger(events)})
+
+	victim := newTestSigner(t)
+	forged := &forgedSigner{Signer: newTestSigner(t), publicKey: victim.PublicKey()}
+	if _, err := connect(t, server, ssh.PublicKeys(forged)); err == nil {
+		t.Fatal("connect() must fail with forged signature")
+	}
+	if got := events.events(t, audit.EventAuth); len(got) != 0 {
+		t.Fatalf("key query without signature logged %+v", got[0])
+	}
+
+	client, err := connect(t, server, ssh.PublicKeys(victim))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	got := events.events(t, audit.EventAuth)
+	want := common.KeyIdentity(common.GetFingerprint(victim.PublicKey()))
+	if len(got) != 1 || got[0].Result != audit.ResultAllowed || got[0].Identity != want {
+		t.Errorf("auth events = %+v, want single allowed event of %s", got, want)
+	}
+}
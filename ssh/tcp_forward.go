@@ -0,0 +1,163 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"r-ssh/common"
+	"r-ssh/ssh/audit"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type tcpForward struct {
+	info     *common.ForwardInfo
+	port     int
+	listener net.Listener
+}
+
+func tcpForwardKey(info *common.ForwardInfo) string {
+	return info.Bind()
+}
+
+// tcpURLs are urls of port under every host, tcp forwards are published under all of them
+func (f *ForwardController) tcpURLs(port int) []string {
+	urls := make([]string, 0, len(f.hosts))
+	for _, host := range f.hosts {
+		urls = append(urls, "tcp://"+net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	return urls
+}
+
+// listenTCP allocates port and listens on it, ports occupied by other programs are skipped
+func (f *ForwardController) listenTCP() (int, net.Listener, error) {
+	ports := f.options.TCPPorts
+	for i := 0; i < ports.Size(); i++ {
+		port, err := ports.Allocate()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		listener, err := net.Listen("tcp", net.JoinHostPort(f.options.TCPListenAddr, strconv.Itoa(port)))
+		if err == nil {
+			return port, listener, nil
+		}
+
+		ports.Release(port)
+		log.WithError(err).WithField("port", port).Warnln("listen tcp forward failed")
+	}
+	return 0, nil, common.ErrNoFreePort
+}
+
+func (f *ForwardController) handleTCPForward(conn *ConnectionWrapper, info *common.ForwardInfo) (interface{}, error) {
+	if f.options.TCPPorts == nil {
+		_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" failed: \"%s\"\r\n", info.Bind(), common.ErrTCPForwardDisabled))
+		return nil, common.ErrTCPForwardDisabled
+	}
+
+	port, listener, err := f.listenTCP()
+	if err != nil {
+		_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" failed: \"%s\"\r\n", info.Bind(), err))
+		return nil, err
+	}
+	forward := &tcpForward{info: info, port: port, listener: listener}
+
+	err = f.addTCPForward(conn, forward)
+	if err != nil {
+		_ = listener.Close()
+		f.options.TCPPorts.Release(port)
+		_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" failed: \"%s\"\r\n", info.Bind(), err))
+		return nil, err
+	}
+	go f.serveTCP(conn, forward)
+
+	f.options.AuditLog.Log(f.tcpEvent(audit.EventTunnelOpen, conn, forward, ""))
+	_, _ = conn.Terminal.WriteString(fmt.Sprintf("forward \"%s\" to %s\r\n", info.Bind(), formatURLs(f.tcpURLs(port))))
+	return forwardResponse(info), nil
+}
+
+func (f *ForwardController) tcpEvent(eventType audit.EventType, conn *ConnectionWrapper, forward *tcpForward, reason string) *audit.Event {
+	event := conn.auditEvent(eventType)
+	event.Bind = tcpForwardKey(forward.info)
+	event.URLs = f.tcpURLs(forward.port)
+	event.URL = event.URLs[0]
+	event.Reason = reason
+	return event
+}
+
+func (f *ForwardController) addTCPForward(conn *ConnectionWrapper, forward *tcpForward) error {
+	f.redirectLock.Lock()
+	defer f.redirectLock.Unlock()
+
+	key := tcpForwardKey(forward.info)
+	if _, ok := f.tcpForwards[conn][key]; ok {
+		return common.ErrForwardAlreadyBinded
+	}
+	if f.options.TCPMaxPorts > 0 && f.identityPorts(conn.Identity) >= f.options.TCPMaxPorts {
+		return common.ErrTooManyPorts
+	}
+
+	forwards, ok := f.tcpForwards[conn]
+	if !ok {
+		forwards = make(map[string]*tcpForward)
+		f.tcpForwards[conn] = forwards
+	}
+	forwards[key] = forward
+	return nil
+}
+
+// identityPorts returns number of tcp ports allocated to all sessions of identity
+func (f *ForwardController) identityPorts(identity string) int {
+	count := 0
+	for conn, forwards := range f.tcpForwards {
+		if conn.Identity == identity {
+			count += len(forwards)
+		}
+	}
+	return count
+}
+
+func (f *ForwardController) removeTCPForward(conn *ConnectionWrapper, info *common.ForwardInfo) {
+	f.redirectLock.Lock()
+	forwards := f.tcpForwards[conn]
+	forward, ok := forwards[tcpForwardKey(info)]
+	if !ok {
+		f.redirectLock.Unlock()
+		return
+	}
+
+	delete(forwards, tcpForwardKey(info))
+	event := f.closeTCPForward(conn, forward, "cancel")
+	f.redirectLock.Unlock()
+	f.options.AuditLog.Log(event)
+}
+
+// closeTCPForward stops accepting new connections, already accepted connections live until either side closes.
+// It returns audit event of the close
+func (f *ForwardController) closeTCPForward(conn *ConnectionWrapper, forward *tcpForward, reason string) *audit.Event {
+	if err := forward.listener.Close(); err != nil {
+		log.WithError(err).Warnln("close tcp forward failed")
+	}
+	f.options.TCPPorts.Release(forward.port)
+	return f.tcpEvent(audit.EventTunnelClose, conn, forward, reason)
+}
+
+func (f *ForwardController) serveTCP(conn *ConnectionWrapper, forward *tcpForward) {
+	for {
+		tcpConn, err := forward.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.pipeTCP(conn, forward, tcpConn)
+	}
+}
+
+func (f *ForwardController) pipeTCP(conn *ConnectionWrapper, forward *tcpForward, tcpConn net.Conn) {
+	channel, err := f.openForwardChannel(conn, forward.info, tcpConn.RemoteAddr())
+	if err != nil {
+		_ = tcpConn.Close()
+		log.WithError(err).Warnln("open tcp forward channel failed")
+		return
+	}
+	common.Pipe(tcpConn, channel)
+}
@@ -0,0 +1,72 @@
+package ssh
+
+import (
+	"r-ssh/ssh/auth"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestForwardController_tcpURLs(t *testing.T) {
+	f := NewForwardController("example.com", ServerOptions{ExtraHosts: []string{"example.internal"}})
+
+	want := []string{"tcp://example.com:42000", "tcp://example.internal:42000"}
+	if got := f.tcpURLs(42000); !reflect.DeepEqual(got, want) {
+		t.Errorf("tcpURLs() = %v, want %v", got, want)
+	}
+}
+
+func TestForwardController_tcpHostPolicies(t *testing.T) {
+	noFlags := ""
+	server := newTestServer(t, auth.DefaultAuthProvider, ServerOptions{
+		ExtraHosts:   []string{"example.internal"},
+		HostPolicies: map[string]*auth.Policy{"example.com": {AllowedFlags: &noFlags}},
+		TCPPorts:     NewPortAllocator(42000, 42100),
+	})
+
+	client, err := connect(t, server, ssh.PublicKeys(newTestSigner(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if requestForward(t, client, "localhost+t", 5432) {
+		t.Error("tcp forward must be rejected by policy of one of the hosts")
+	}
+}
+
+func TestForwardController_tcpMaxPorts(t *testing.T) {
+	server := newTestServer(t, auth.DefaultAuthProvider, ServerOptions{
+		TCPPorts:    NewPortAllocator(42100, 42200),
+		TCPMaxPorts: 1,
+	})
+
+	signer := newTestSigner(t)
+	first, err := connect(t, server, ssh.PublicKeys(signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := connect(t, server, ssh.PublicKeys(signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	if !requestForward(t, first, "localhost+t", 5432) {
+		t.Fatal("tcp forward failed")
+	}
+	if requestForward(t, second, "localhost+t", 5432) {
+		t.Error("tcp forward over limit of the identity must fail")
+	}
+
+	other, err := connect(t, server, ssh.PublicKeys(newTestSigner(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if !requestForward(t, other, "localhost+t", 5432) {
+		t.Error("tcp forward of other identity failed")
+	}
+}
@@ -17,6 +17,7 @@ type BasicTerminal struct {
 	messageWriter io.Writer
 
 	messageMutex sync.Mutex
+	attached     bool
 }
 
 func NewBasicTerminal(connection *ssh.ServerConn) *BasicTerminal {
@@ -72,21 +73,18 @@ func (b *BasicTerminal) handleKeyboard(channel ssh.Channel) {
 	}
 }
 
-func (b *BasicTerminal) rejectChannels(channels <-chan ssh.NewChannel) {
-	for newChannel := range channels {
-		channelType := newChannel.ChannelType()
-		if channelType != "session" {
-			err := newChannel.Reject(ssh.ResourceShortage, "not allowed")
-			if err != nil {
-				b.logger.WithError(err).Warnln("reject channel failed")
-			}
-		}
+// HandleChannel attaches the first session channel as terminal, later sessions are closed with message
+func (b *BasicTerminal) HandleChannel(newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		b.logger.WithError(err).Warnln("accept channel failed")
+		return
+	}
 
-		channel, _, err := newChannel.Accept()
-		if err != nil {
-			b.logger.WithError(err).Warnln("accept channel failed")
-			continue
-		}
+	b.messageMutex.Lock()
+	if b.attached {
+		b.messageMutex.Unlock()
+		go ssh.DiscardRequests(requests)
 
 		_, err = channel.Write([]byte(common.MultipleSessionMessage))
 		if err != nil {
@@ -96,39 +94,17 @@ func (b *BasicTerminal) rejectChannels(channels <-chan ssh.NewChannel) {
 		if err != nil {
 			b.logger.WithError(err).Warnln("channel close failed")
 		}
+		return
 	}
-}
+	b.attached = true
 
-func (b *BasicTerminal) HandleChannels(channels <-chan ssh.NewChannel) {
-	for newChannel := range channels {
-		channelType := newChannel.ChannelType()
-		if channelType != "session" {
-			err := newChannel.Reject(ssh.UnknownChannelType, "not supported")
-			if err != nil {
-				b.logger.WithError(err).Warnln("reject channel failed")
-			}
-			continue
-		}
-
-		channel, requests, err := newChannel.Accept()
-		if err != nil {
-			b.logger.WithError(err).Warnln("accept channel failed")
-			continue
-		}
+	go b.handleTerminalRequests(requests)
 
-		go b.handleTerminalRequests(requests)
+	_, _ = io.Copy(channel, b.messageBuffer)
+	b.messageBuffer.Reset()
+	b.messageWriter = channel
 
-		b.messageMutex.Lock()
-
-		_, _ = io.Copy(channel, b.messageBuffer)
-		b.messageBuffer.Reset()
-		b.messageWriter = channel
-
-		b.messageMutex.Unlock()
-
-		go b.handleKeyboard(channel)
-		break
-	}
+	b.messageMutex.Unlock()
 
-	b.rejectChannels(channels)
+	go b.handleKeyboard(channel)
 }
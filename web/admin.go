@@ -0,0 +1,161 @@
+package web
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"r-ssh/common"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var adminPathPrefix = []byte("/admin/")
+
+func (s *Server) adminAuthorized(ctx *fasthttp.RequestCtx) bool {
+	expected := []byte("Bearer " + s.adminToken)
+	return subtle.ConstantTimeCompare(ctx.Request.Header.Peek("Authorization"), expected) == 1
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, value interface{}) {
+	ctx.SetContentType("application/json")
+	if err := json.NewEncoder(ctx).Encode(value); err != nil {
+		ctx.Error(err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// bansHandler lists banned ips on GET, DELETE removes ban of "ip" query argument or all bans
+func (s *Server) bansHandler(ctx *fasthttp.RequestCtx) {
+	banList := s.sshServer.BanList()
+	if banList == nil {
+		ctx.Error("banning disabled", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case ctx.IsGet():
+		bans := make(map[string]string)
+		for ip, until := range banList.Bans() {
+			bans[ip] = until.UTC().Format(time.RFC3339)
+		}
+		writeJSON(ctx, bans)
+	case ctx.IsDelete():
+		banList.Unban(string(ctx.QueryArgs().Peek("ip")))
+		ctx.SetStatusCode(http.StatusNoContent)
+	default:
+		ctx.Error("method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// namesHandler lists reserved names on GET, DELETE releases name from "name" query argument
+func (s *Server) namesHandler(ctx *fasthttp.RequestCtx) {
+	registry := s.sshServer.NameRegistry()
+	if registry == nil {
+		ctx.Error("names disabled", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case ctx.IsGet():
+		writeJSON(ctx, registry.Names())
+	case ctx.IsDelete():
+		name := string(ctx.QueryArgs().Peek("name"))
+		if name == "" {
+			ctx.Error("name required", http.StatusBadRequest)
+			return
+		}
+
+		err := registry.Release(name)
+		switch {
+		case errors.Is(err, common.ErrNameNotFound):
+			ctx.Error(err.Error(), http.StatusNotFound)
+		case err != nil:
+			logger.WithError(err).Warnln("release name failed")
+			ctx.Error(err.Error(), http.StatusInternalServerError)
+		default:
+			ctx.SetStatusCode(http.StatusNoContent)
+		}
+	default:
+		ctx.Error("method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// domainsHandler lists custom domains on GET, DELETE releases domain from "domain" query argument
+func (s *Server) domainsHandler(ctx *fasthttp.RequestCtx) {
+	registry := s.sshServer.DomainRegistry()
+	if registry == nil {
+		ctx.Error("custom domains disabled", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case ctx.IsGet():
+		writeJSON(ctx, registry.Domains())
+	case ctx.IsDelete():
+		domain := string(ctx.QueryArgs().Peek("domain"))
+		if domain == "" {
+			ctx.Error("domain required", http.StatusBadRequest)
+			return
+		}
+
+		err := registry.Release(domain)
+		switch {
+		case errors.Is(err, common.ErrDomainNotFound):
+			ctx.Error(err.Error(), http.StatusNotFound)
+		case err != nil:
+			logger.WithError(err).Warnln("release domain failed")
+			ctx.Error(err.Error(), http.StatusInternalServerError)
+		default:
+			ctx.SetStatusCode(http.StatusNoContent)
+		}
+	default:
+		ctx.Error("method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// metricsHandler reports sessions, forward channels and keep-alive connections in prometheus text format
+func (s *Server) metricsHandler(ctx *fasthttp.RequestCtx) {
+	opened, closed := s.sshServer.ForwardController().ChannelStats()
+	idle, reused := s.conns.stats()
+
+	ctx.SetContentType("text/plain; version=0.0.4")
+	writeMetric(ctx, "rssh_sessions", "gauge", "Connected ssh clients.", uint64(s.sshServer.SessionCount()))
+	writeMetric(ctx, "rssh_channels_opened_total", "counter", "Forward channels opened.", opened)
+	writeMetric(ctx, "rssh_channels_closed_total", "counter", "Forward channels closed.", closed)
+	writeMetric(ctx, "rssh_channels_open", "gauge", "Forward channels in use.", opened-closed)
+	writeMetric(ctx, "rssh_idle_connections", "gauge", "Keep-alive connections waiting for requests.", uint64(idle))
+	writeMetric(ctx, "rssh_reused_connections_total", "counter", "Requests sent over keep-alive connections.", reused)
+}
+
+func writeMetric(w io.Writer, name, kind, help string, value uint64) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+// adminHandler serves runtime management endpoints, they're disabled without admin token
+func (s *Server) adminHandler(ctx *fasthttp.RequestCtx) {
+	if s.adminToken == "" {
+		ctx.Error("not found", http.StatusNotFound)
+		return
+	}
+	if !s.adminAuthorized(ctx) {
+		ctx.Error("unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch string(bytes.TrimPrefix(ctx.Path(), adminPathPrefix)) {
+	case "bans":
+		s.bansHandler(ctx)
+	case "names":
+		s.namesHandler(ctx)
+	case "domains":
+		s.domainsHandler(ctx)
+	case "metrics":
+		s.metricsHandler(ctx)
+	default:
+		ctx.Error("not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"r-ssh/certs"
+	"r-ssh/common"
+	"r-ssh/ssh"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var acmeChallengePrefix = []byte("/.well-known/acme-challenge/")
+
+// NewCertManager issues certificates of verified custom domains on demand, caFile adds roots trusted
+// for the ACME directory, e.g. of local CA. Certificates are kept in cacheDir, empty cacheDir keeps them in memory
+func NewCertManager(directoryURL, email, cacheDir, caFile string, domains *ssh.DomainRegistry) (*autocert.Manager, error) {
+	client, err := certs.NewClient(directoryURL, caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	manager := &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Email:  email,
+		Client: client,
+		HostPolicy: func(_ context.Context, host string) error {
+			if _, ok := domains.Owner(host); !ok {
+				return common.ErrDomainNotVerified
+			}
+			return nil
+		},
+	}
+	if cacheDir != "" {
+		manager.Cache = autocert.DirCache(cacheDir)
+	}
+	return manager, nil
+}
+
+// isCustomDomain reports whether tls server name is custom domain rather than the application host
+func (s *Server) isCustomDomain(serverName string) bool {
+	_, host, ok := s.routeOf([]byte(serverName))
+	return ok && host == ""
+}
+
+// getCertificate gets certificate of custom domain from ACME, the application host uses configured certificates
+func (s *Server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if s.certManager != nil && s.isCustomDomain(hello.ServerName) {
+		return s.certManager.GetCertificate(hello)
+	}
+	return s.certificates.GetCertificate(hello)
+}
+
+// wellKnownHandler answers ACME http-01 challenges, it returns false for other requests
+func (s *Server) wellKnownHandler(ctx *fasthttp.RequestCtx) bool {
+	if s.certManager == nil || !bytes.HasPrefix(ctx.Path(), acmeChallengePrefix) {
+		return false
+	}
+	fasthttpadaptor.NewFastHTTPHandler(s.certManager.HTTPHandler(nil))(ctx)
+	return true
+}
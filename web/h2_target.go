@@ -0,0 +1,187 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"r-ssh/ssh"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// streamWriter hands response of net/http proxy to fasthttp. The body goes through pipe, so every write
+// reaches the client as it arrives from the target and slow clients push back to the proxy
+type streamWriter struct {
+	header http.Header
+	// statusCode and sent are the final header as written, the proxy adds trailers to header later
+	statusCode int
+	sent       http.Header
+	// ready is closed once the header is written
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	body *io.PipeWriter
+	// trailer is set when the response announces trailers, values are filled in once the body ends
+	trailer http.Header
+}
+
+func (w *streamWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *streamWriter) WriteHeader(statusCode int) {
+	if isInterim(statusCode) {
+		return
+	}
+	w.readyOnce.Do(func() {
+		w.statusCode = statusCode
+		w.sent = w.header.Clone()
+		for _, names := range w.sent["Trailer"] {
+			for _, name := range strings.Split(names, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					if w.trailer == nil {
+						w.trailer = make(http.Header)
+					}
+					w.trailer[http.CanonicalHeaderKey(name)] = nil
+				}
+			}
+		}
+		close(w.ready)
+	})
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// Flush is no-op, every write is passed to the client right away
+func (w *streamWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
+
+// finish ends the body, trailers set by the proxy after the body are collected before
+func (w *streamWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+	defer w.body.Close()
+	if w.trailer == nil {
+		return
+	}
+
+	for name, values := range w.header {
+		if strings.HasPrefix(name, http.TrailerPrefix) {
+			w.trailer[http.CanonicalHeaderKey(name[len(http.TrailerPrefix):])] = values
+		} else if _, ok := w.trailer[name]; ok {
+			w.trailer[name] = values
+		}
+	}
+}
+
+// streamBody is response body read from the proxy, closing it stops the proxy and releases the forward
+type streamBody struct {
+	*io.PipeReader
+	cancel  context.CancelFunc
+	forward *ssh.Forward
+
+	closeOnce sync.Once
+}
+
+func (b *streamBody) Close() error {
+	b.closeOnce.Do(func() {
+		_ = b.PipeReader.Close()
+		b.cancel()
+		b.forward.Release()
+	})
+	return nil
+}
+
+// newProxyRequest converts request of fasthttp client to net/http request
+func newProxyRequest(ctx *fasthttp.RequestCtx) (*http.Request, error) {
+	// the body is sent after the handler returns, when fasthttp reuses its buffer
+	body := append([]byte(nil), ctx.PostBody()...)
+	req, err := http.NewRequest(string(ctx.Method()), string(ctx.RequestURI()), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		req.Header.Add(string(key), string(value))
+	})
+	req.Header.Del("Host")
+	req.Host = string(ctx.Host())
+	req.RemoteAddr = ctx.RemoteAddr().String()
+	return req, nil
+}
+
+// proxyH2Target proxies request of http/1.1 client to h2 target by net/http, the response is streamed to the client.
+// fasthttp can't write trailers, responses announcing them are written to hijacked connection closed afterwards
+func (s *Server) proxyH2Target(ctx *fasthttp.RequestCtx, forward *ssh.Forward) {
+	req, err := newProxyRequest(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	proxy := s.proxy(forward, ctx.RemoteAddr(), ctx.IsTLS())
+	reqCtx, cancel := context.WithCancel(context.Background())
+	reader, writer := io.Pipe()
+	w := &streamWriter{header: make(http.Header), ready: make(chan struct{}), body: writer}
+	go func() {
+		defer w.finish()
+		// the proxy aborts the handler when copying the body fails, e.g. when the client is gone
+		defer func() {
+			if r := recover(); r != nil && r != http.ErrAbortHandler {
+				panic(r)
+			}
+		}()
+		proxy.ServeHTTP(w, req.WithContext(reqCtx))
+	}()
+	<-w.ready
+
+	body := &streamBody{PipeReader: reader, cancel: cancel, forward: forward}
+	if w.trailer != nil && !ctx.IsHead() {
+		resp := &http.Response{
+			StatusCode:       w.statusCode,
+			ProtoMajor:       1,
+			ProtoMinor:       1,
+			Header:           w.sent,
+			Body:             body,
+			ContentLength:    -1,
+			TransferEncoding: []string{"chunked"},
+			Close:            true,
+			Trailer:          w.trailer,
+		}
+		ctx.HijackSetNoResponse(true)
+		s.hijacks.hijack(ctx, func(clientConn net.Conn) {
+			_ = resp.Write(clientConn)
+		}, func() {
+			_ = body.Close()
+		})
+		return
+	}
+
+	ctx.SetStatusCode(w.statusCode)
+	for name, values := range w.sent {
+		for _, value := range values {
+			switch name {
+			case "Content-Length", "Transfer-Encoding", "Connection", "Date":
+				// managed by fasthttp
+			case "Content-Type", "Server", "Set-Cookie":
+				ctx.Response.Header.Set(name, value)
+			default:
+				ctx.Response.Header.Add(name, value)
+			}
+		}
+	}
+	contentLength := -1
+	if length, err := strconv.Atoi(w.sent.Get("Content-Length")); err == nil {
+		contentLength = length
+	}
+	ctx.Response.ImmediateHeaderFlush = true
+	ctx.Response.SetBodyStream(body, contentLength)
+}
@@ -0,0 +1,73 @@
+package web
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"r-ssh/common"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestServer_proxyH2TargetStreamsBody(t *testing.T) {
+	next := make(chan struct{})
+	forward := pipeForward(common.BuildForwardInfo("alice", common.DefaultForwardAddr+"+h", 80), h2cTarget(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "one")
+		w.(http.Flusher).Flush()
+		// the rest is sent only after the client got the first piece
+		<-next
+		_, _ = io.WriteString(w, "two")
+	}))
+
+	s := &Server{maxBufferSize: DefaultMaxBufferSize, conns: newConnPool(0, 0)}
+	listener := serve(t, s, func(ctx *fasthttp.RequestCtx) {
+		s.proxyH2Target(ctx, forward)
+	})
+
+	resp, err := newTestClient(listener).Get("http://app.example.com/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	piece := make([]byte, 3)
+	if _, err = io.ReadFull(resp.Body, piece); err != nil || string(piece) != "one" {
+		t.Fatalf("first piece = %q, %v, want one", piece, err)
+	}
+	close(next)
+	if rest, err := ioutil.ReadAll(resp.Body); err != nil || string(rest) != "two" {
+		t.Errorf("rest = %q, %v, want two", rest, err)
+	}
+}
+
+func TestServer_proxyH2TargetTrailers(t *testing.T) {
+	forward := pipeForward(common.BuildForwardInfo("alice", common.DefaultForwardAddr+"+h", 80), h2cTarget(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = io.WriteString(w, "reply")
+		w.Header().Set("Grpc-Status", "0")
+	}))
+
+	s := &Server{maxBufferSize: DefaultMaxBufferSize, conns: newConnPool(0, 0)}
+	listener := serve(t, s, func(ctx *fasthttp.RequestCtx) {
+		s.proxyH2Target(ctx, forward)
+	})
+
+	resp, err := newTestClient(listener).Get("http://app.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || string(body) != "reply" {
+		t.Fatalf("body = %q, %v, want reply", body, err)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Grpc-Status trailer = %q, want 0", got)
+	}
+}
@@ -0,0 +1,53 @@
+package web
+
+import (
+	"net"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// hijackGuard runs abort of hijacked requests whose hijack handler never runs. fasthttp closes the connection
+// instead of hijacking it when writing the response fails or the connection has to be closed after it
+type hijackGuard struct {
+	lock    sync.Mutex
+	pending map[net.Conn]func()
+}
+
+// hijack sets handler of the client connection, abort releases what the handler would when it's not called.
+// Only one of them runs
+func (g *hijackGuard) hijack(ctx *fasthttp.RequestCtx, handler fasthttp.HijackHandler, abort func()) {
+	var once sync.Once
+	conn := ctx.Conn()
+
+	g.lock.Lock()
+	if g.pending == nil {
+		g.pending = make(map[net.Conn]func())
+	}
+	g.pending[conn] = func() { once.Do(abort) }
+	g.lock.Unlock()
+
+	ctx.Hijack(func(clientConn net.Conn) {
+		g.remove(conn)
+		once.Do(func() { handler(clientConn) })
+	})
+}
+
+func (g *hijackGuard) remove(conn net.Conn) func() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	abort := g.pending[conn]
+	delete(g.pending, conn)
+	return abort
+}
+
+// connState aborts pending hijack of closed connection, hijacked connections never get to StateClosed
+func (g *hijackGuard) connState(conn net.Conn, state fasthttp.ConnState) {
+	if state != fasthttp.StateClosed {
+		return
+	}
+	if abort := g.remove(conn); abort != nil {
+		abort()
+	}
+}
@@ -0,0 +1,310 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/http/httputil"
+	"r-ssh/common"
+	"r-ssh/ssh"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// forwardTransport sends requests of net/http handlers to the forward. Keep-alive connections of http/1.1 targets
+// are shared with fasthttp handler, h2 targets get multiplexed connection per pool member
+type forwardTransport struct {
+	server  *Server
+	forward *ssh.Forward
+	// origin is the client, the forward channel is opened on its behalf
+	origin net.Addr
+}
+
+func (t *forwardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	roundTrip := t.roundTripHTTP1
+	if t.forward.Info.HTTP2 {
+		roundTrip = t.roundTripH2
+	}
+
+	resp, source, err := roundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if !t.server.hideInfo {
+		resp.Header.Set("X-Source", source.String())
+	}
+	return resp, nil
+}
+
+// roundTripHTTP1 sends request over idle connection of the member or over new channel, see exchange
+func (t *forwardTransport) roundTripHTTP1(req *http.Request) (*http.Response, net.Addr, error) {
+	s := t.server
+	for {
+		conn := s.conns.get(t.forward.MemberID)
+		reused := conn != nil
+		if !reused {
+			var err error
+			if conn, err = s.dial(t.origin, t.forward); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		resp, err := s.exchangeHTTP(req, conn)
+		if err == nil {
+			resp.Body = &pooledBody{
+				ReadCloser: resp.Body,
+				conn:       conn,
+				memberID:   t.forward.MemberID,
+				pool:       s.conns,
+				reusable:   !resp.Close,
+				done:       resp.Body == http.NoBody,
+			}
+			return resp, conn.RemoteAddr(), nil
+		}
+
+		_ = conn.Close()
+		if !reused || isTimeout(err) || !isReplayable(req.Method) {
+			return nil, nil, err
+		}
+	}
+}
+
+// exchangeHTTP writes request and reads response headers of http/1.1 target, see sendRequest and readResponseHeader
+func (s *Server) exchangeHTTP(req *http.Request, conn *forwardConn) (*http.Response, error) {
+	if s.upstreamTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(s.upstreamTimeout))
+	}
+
+	bw := bufio.NewWriter(conn)
+	err := req.Write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(conn.br, req)
+	for err == nil && isInterim(resp.StatusCode) {
+		resp, err = http.ReadResponse(conn.br, req)
+	}
+	if err == nil && s.upstreamTimeout > 0 {
+		_ = conn.SetDeadline(time.Time{})
+	}
+	return resp, err
+}
+
+// pooledBody puts connection back to the pool once the body was read to the end, otherwise it's closed
+type pooledBody struct {
+	io.ReadCloser
+	conn     *forwardConn
+	memberID string
+	pool     *connPool
+	reusable bool
+	done     bool
+
+	closeOnce sync.Once
+}
+
+func (b *pooledBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.done = true
+	}
+	return n, err
+}
+
+// Close doesn't close the body itself, net/http would read the rest of it
+func (b *pooledBody) Close() error {
+	b.closeOnce.Do(func() {
+		if b.reusable && b.done {
+			b.pool.put(b.memberID, b.conn)
+		} else {
+			_ = b.conn.Close()
+		}
+	})
+	return nil
+}
+
+// roundTripH2 sends request over connection of the member shared by its requests, upstream timeout cancels
+// requests without response headers in time
+func (t *forwardTransport) roundTripH2(req *http.Request) (*http.Response, net.Addr, error) {
+	s := t.server
+	transport := s.conns.acquireH2(t.forward.MemberID, t.newH2Transport)
+
+	var source net.Addr
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { source = info.Conn.RemoteAddr() }}
+	ctx, cancel := context.WithCancel(httptrace.WithClientTrace(req.Context(), trace))
+	release := func() {
+		cancel()
+		s.conns.releaseH2(t.forward.MemberID, transport)
+	}
+
+	var timer *time.Timer
+	if s.upstreamTimeout > 0 {
+		timer = time.AfterFunc(s.upstreamTimeout, cancel)
+	}
+	resp, err := transport.RoundTrip(req.WithContext(ctx))
+	if timer != nil && !timer.Stop() {
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		err = common.ErrUpstreamTimeout
+	}
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	return resp, source, nil
+}
+
+// newH2Transport dials the member for h2 connection, h2 forwards use h2c unless https flag is set
+func (t *forwardTransport) newH2Transport() *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(_, _ string, config *tls.Config) (net.Conn, error) {
+			conn, info, err := t.forward.Handler(t.origin)
+			if err != nil || !info.Https {
+				return conn, err
+			}
+
+			tlsConn := tls.Client(conn, config)
+			if err = tlsConn.Handshake(); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		},
+	}
+}
+
+// releaseBody calls release once when the body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+
+	releaseOnce sync.Once
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.releaseOnce.Do(b.release)
+	return err
+}
+
+// removeCookie drops cookie of the application, the target gets only its own cookies
+func removeCookie(req *http.Request, name string) {
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+
+	values := make([]string, 0, len(cookies))
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			values = append(values, cookie.Name+"="+cookie.Value)
+		}
+	}
+	if len(values) > 0 {
+		req.Header.Set("Cookie", strings.Join(values, "; "))
+	}
+}
+
+// proxy forwards net/http request of client at origin to the forward, it's used for h2 clients and for h2 targets
+func (s *Server) proxy(forward *ssh.Forward, origin net.Addr, isTLS bool) *httputil.ReverseProxy {
+	info := forward.Info
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			removeCookie(req, stickyCookie)
+
+			req.Header.Set("Via", common.ApplicationName)
+			// X-Forwarded-For is set by ReverseProxy from remote address
+			req.Header.Del("X-Forwarded-For")
+			req.Header.Set("X-Forwarded-Host", req.Host)
+			if isTLS {
+				req.Header.Set("X-Forwarded-Proto", "https")
+			} else {
+				req.Header.Set("X-Forwarded-Proto", "http")
+			}
+
+			req.Host = info.Host
+			req.URL.Host = info.Host
+			if info.RewriteOrigin && req.Header.Get("Origin") != "" {
+				req.Header.Set("Origin", info.Host)
+			}
+
+			if info.Https {
+				req.URL.Scheme = "https"
+			} else {
+				req.URL.Scheme = "http"
+			}
+		},
+		Transport: &forwardTransport{server: s, forward: forward, origin: origin},
+		// streaming responses (e.g. grpc) are flushed immediately
+		FlushInterval: -1,
+		ModifyResponse: func(resp *http.Response) error {
+			if info.Sticky {
+				cookie := &http.Cookie{Name: stickyCookie, Value: forward.MemberID, Path: "/", HttpOnly: true}
+				resp.Header.Add("Set-Cookie", cookie.String())
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			logger.WithError(err).Warnln("forward request failed")
+			if isTimeout(err) {
+				http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			} else {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+			}
+		},
+	}
+}
+
+// h2Handler serves requests of h2 clients, status is left to http/1.1 connections
+func (s *Server) h2Handler(w http.ResponseWriter, req *http.Request) {
+	subdomain, host, ok := s.routeOf([]byte(req.Host))
+	if !ok {
+		http.Error(w, "subdomain required", http.StatusBadRequest)
+		return
+	}
+	if subdomain == "status" {
+		http.Error(w, "status is served over http/1.1", http.StatusMisdirectedRequest)
+		return
+	}
+
+	stickyID := ""
+	if cookie, err := req.Cookie(stickyCookie); err == nil {
+		stickyID = cookie.Value
+	}
+
+	forward, err := s.sshServer.ForwardController().AcquireForward(host, subdomain, stickyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer forward.Release()
+
+	if forward.Info.Passthrough {
+		http.Error(w, "tls passthrough forward", http.StatusMisdirectedRequest)
+		return
+	}
+
+	origin, err := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.proxy(forward, origin, req.TLS != nil).ServeHTTP(w, req)
+}
+
+// serveHTTP2 serves tls connection which negotiated h2 with ALPN
+func (s *Server) serveHTTP2(conn net.Conn) {
+	s.h2Server.ServeConn(conn, &http2.ServeConnOpts{Handler: http.HandlerFunc(s.h2Handler)})
+}
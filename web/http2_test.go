@@ -0,0 +1,150 @@
+package web
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"r-ssh/common"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestRemoveCookie(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+	req.Header.Add("Cookie", "session=abc; "+stickyCookie+"=1")
+	req.Header.Add("Cookie", "theme=dark")
+
+	removeCookie(req, stickyCookie)
+	if got := req.Header.Get("Cookie"); got != "session=abc; theme=dark" {
+		t.Errorf("Cookie = %q, want \"session=abc; theme=dark\"", got)
+	}
+
+	req.Header.Set("Cookie", stickyCookie+"=1")
+	removeCookie(req, stickyCookie)
+	if _, ok := req.Header["Cookie"]; ok {
+		t.Errorf("Cookie = %q, want no header", req.Header.Get("Cookie"))
+	}
+}
+
+// h2cTarget serves handler over h2c on every forward connection
+func h2cTarget(handler http.HandlerFunc) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		(&http2.Server{}).ServeConn(conn, &http2.ServeConnOpts{Handler: handler})
+	}
+}
+
+// http1Target answers every request on the connection with body
+func http1Target(body string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			_, _ = io.Copy(ioutil.Discard, req.Body)
+			_, _ = fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+		}
+	}
+}
+
+func TestServer_proxyTrailers(t *testing.T) {
+	info := common.BuildForwardInfo("alice", common.DefaultForwardAddr+"+h", 80)
+	// grpc server speaking h2c, status is sent in trailers
+	forward := pipeForward(info, h2cTarget(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("Content-Type", "application/grpc")
+		_, _ = w.Write(append([]byte("reply to "), body...))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+
+	s := &Server{maxBufferSize: DefaultMaxBufferSize, conns: newConnPool(0, 0)}
+	req := httptest.NewRequest(http.MethodPost, "https://app.example.com/service/Method", strings.NewReader("request"))
+	recorder := httptest.NewRecorder()
+	s.proxy(forward, &net.TCPAddr{}, true).ServeHTTP(recorder, req)
+
+	resp := recorder.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "reply to request" {
+		t.Fatalf("response = %d %q, want 200 \"reply to request\"", resp.StatusCode, body)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Grpc-Status trailer = %q, want 0", got)
+	}
+}
+
+func TestServer_proxyReusesConns(t *testing.T) {
+	tests := []struct {
+		name   string
+		addr   string
+		target func(conn net.Conn)
+	}{
+		{"http1", common.DefaultForwardAddr, http1Target("ok")},
+		{"h2", common.DefaultForwardAddr + "+h", h2cTarget(func(w http.ResponseWriter, req *http.Request) {
+			_, _ = io.WriteString(w, "ok")
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dials int32
+			forward := pipeForward(common.BuildForwardInfo("alice", tt.addr, 80), tt.target)
+			handler := forward.Handler
+			forward.Handler = func(origin net.Addr) (net.Conn, *common.ForwardInfo, error) {
+				atomic.AddInt32(&dials, 1)
+				return handler(origin)
+			}
+
+			s := &Server{maxBufferSize: DefaultMaxBufferSize, conns: newConnPool(2, time.Minute)}
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+				recorder := httptest.NewRecorder()
+				s.proxy(forward, &net.TCPAddr{}, true).ServeHTTP(recorder, req)
+				if recorder.Code != http.StatusOK || recorder.Body.String() != "ok" {
+					t.Fatalf("response = %d %q, want 200 \"ok\"", recorder.Code, recorder.Body.String())
+				}
+			}
+			if got := atomic.LoadInt32(&dials); got != 1 {
+				t.Errorf("forward dialed %d times, want 1", got)
+			}
+		})
+	}
+}
+
+func TestServer_proxyUpstreamTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		addr   string
+		target func(conn net.Conn)
+	}{
+		// targets read the request and never respond
+		{"http1", common.DefaultForwardAddr, func(conn net.Conn) {
+			_, _ = io.Copy(ioutil.Discard, conn)
+		}},
+		{"h2", common.DefaultForwardAddr + "+h", h2cTarget(func(w http.ResponseWriter, req *http.Request) {
+			<-req.Context().Done()
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forward := pipeForward(common.BuildForwardInfo("alice", tt.addr, 80), tt.target)
+			s := &Server{maxBufferSize: DefaultMaxBufferSize, conns: newConnPool(0, 0), upstreamTimeout: 50 * time.Millisecond}
+
+			req := httptest.NewRequest(http.MethodGet, "https://app.example.com/", nil)
+			recorder := httptest.NewRecorder()
+			s.proxy(forward, &net.TCPAddr{}, true).ServeHTTP(recorder, req)
+			if recorder.Code != http.StatusGatewayTimeout {
+				t.Errorf("status = %d, want %d", recorder.Code, http.StatusGatewayTimeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package web
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"r-ssh/common"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/net/http2"
+)
+
+// sniffTimeout limits time the client has to send ClientHello
+const sniffTimeout = 10 * time.Second
+
+var errServerNameRead = errors.New("server name read")
+
+// sniffConn lets tls handshake read the ClientHello without writing anything back
+type sniffConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (s *sniffConn) Read(b []byte) (int, error)  { return s.reader.Read(b) }
+func (s *sniffConn) Write(b []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+// peekedConn replays bytes read while looking for server name
+type peekedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (p *peekedConn) Read(b []byte) (int, error) { return p.reader.Read(b) }
+
+func (p *peekedConn) CloseWrite() error {
+	if closer, ok := p.Conn.(interface{ CloseWrite() error }); ok {
+		return closer.CloseWrite()
+	}
+	return p.Conn.Close()
+}
+
+// readServerName returns SNI of the ClientHello and connection which still starts with the ClientHello
+func readServerName(conn net.Conn) (string, net.Conn) {
+	peeked := &bytes.Buffer{}
+	serverName := ""
+
+	_ = tls.Server(&sniffConn{Conn: conn, reader: io.TeeReader(conn, peeked)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errServerNameRead
+		},
+	}).Handshake()
+
+	return serverName, &peekedConn{Conn: conn, reader: io.MultiReader(peeked, conn)}
+}
+
+// passthroughListener accepts tls connections, pipes connections taken by route, serves h2 connections
+// with serveH2 and returns the rest as tls connections
+type passthroughListener struct {
+	net.Listener
+	tlsConfig *tls.Config
+	route     func(serverName string, conn net.Conn) bool
+	serveH2   func(conn net.Conn)
+
+	conns chan net.Conn
+	done  chan struct{}
+	err   error
+}
+
+func (p *passthroughListener) serve() {
+	for {
+		conn, err := p.Listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				logger.WithError(err).Warnln("accept failed")
+				time.Sleep(time.Second)
+				continue
+			}
+
+			p.err = err
+			close(p.done)
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *passthroughListener) handle(conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	serverName, peeked := readServerName(conn)
+	_ = conn.SetReadDeadline(time.Time{})
+
+	if serverName != "" && p.route(serverName, peeked) {
+		return
+	}
+
+	tlsConn := tls.Server(peeked, p.tlsConfig)
+	// handshake is completed here, negotiated protocol decides which server takes the connection
+	_ = conn.SetDeadline(time.Now().Add(sniffTimeout))
+	err := tlsConn.Handshake()
+	_ = conn.SetDeadline(time.Time{})
+	if err != nil {
+		logger.WithError(err).Debugln("tls handshake failed")
+		_ = conn.Close()
+		return
+	}
+
+	switch tlsConn.ConnectionState().NegotiatedProtocol {
+	case http2.NextProtoTLS:
+		p.serveH2(tlsConn)
+		return
+	case acme.ALPNProto:
+		// tls-alpn-01 challenge is answered by the handshake itself
+		_ = tlsConn.Close()
+		return
+	}
+
+	select {
+	case p.conns <- tlsConn:
+	case <-p.done:
+		_ = conn.Close()
+	}
+}
+
+func (p *passthroughListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-p.conns:
+		return conn, nil
+	case <-p.done:
+		return nil, p.err
+	}
+}
+
+func newPassthroughListener(listener net.Listener, tlsConfig *tls.Config, route func(serverName string, conn net.Conn) bool, serveH2 func(conn net.Conn)) net.Listener {
+	passthrough := &passthroughListener{
+		Listener:  listener,
+		tlsConfig: tlsConfig,
+		route:     route,
+		serveH2:   serveH2,
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+	}
+	go passthrough.serve()
+	return passthrough
+}
+
+// passthrough pipes connection to forward registered in passthrough mode, it returns false for other forwards
+func (s *Server) passthrough(serverName string, conn net.Conn) bool {
+	subdomain, host, ok := s.routeOf([]byte(serverName))
+	if !ok {
+		return false
+	}
+
+	forward, err := s.sshServer.ForwardController().AcquireForward(host, subdomain, "")
+	if err != nil {
+		return false
+	}
+	defer forward.Release()
+
+	if !forward.Info.Passthrough {
+		return false
+	}
+
+	forwardConn, _, err := forward.Handler(conn.RemoteAddr())
+	if err != nil {
+		logger.WithError(err).Warnln("create passthrough forward failed")
+		_ = conn.Close()
+		return true
+	}
+
+	common.Pipe(conn, forwardConn)
+	return true
+}
@@ -0,0 +1,51 @@
+package web
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestReadServerName(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		_ = tls.Client(client, &tls.Config{ServerName: "app.example.com", InsecureSkipVerify: true}).Handshake()
+	}()
+
+	serverName, peeked := readServerName(server)
+	if serverName != "app.example.com" {
+		t.Errorf("readServerName() = %q, want app.example.com", serverName)
+	}
+
+	// ClientHello must be replayed to whoever handles the connection next
+	header := make([]byte, 5)
+	if _, err := peeked.Read(header); err != nil {
+		t.Fatalf("Read() error: %s", err)
+	}
+	if header[0] != 0x16 {
+		t.Errorf("replayed record type = %x, want handshake", header[0])
+	}
+	_ = peeked.Close()
+}
+
+func TestReadServerName_NotTLS(t *testing.T) {
+	client, server := net.Pipe()
+
+	go func() {
+		_, _ = client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		_ = client.Close()
+	}()
+
+	serverName, peeked := readServerName(server)
+	if serverName != "" {
+		t.Errorf("readServerName() = %q, want empty", serverName)
+	}
+
+	data, _ := ioutil.ReadAll(peeked)
+	if string(data) != "GET / HTTP/1.1\r\n\r\n" {
+		t.Errorf("replayed data = %q", data)
+	}
+}
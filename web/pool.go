@@ -0,0 +1,196 @@
+package web
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// forwardConn is connection to the target with its response reader, keep-alive connections are reused
+// for requests to the same pool member
+type forwardConn struct {
+	net.Conn
+	// channel is the forward channel, Conn wraps it with tls for https targets
+	channel net.Conn
+	br      *bufio.Reader
+
+	idleSince time.Time
+}
+
+// alive reports the connection can carry another request, the target didn't close it or send unexpected data
+func (c *forwardConn) alive() bool {
+	if closer, ok := c.channel.(interface{ PeerClosed() bool }); ok && closer.PeerClosed() {
+		return false
+	}
+	return c.br.Buffered() == 0
+}
+
+// h2Transport is multiplexed connection to h2 target of the member, active counts requests in flight
+type h2Transport struct {
+	*http2.Transport
+	active    int
+	idleSince time.Time
+}
+
+// connPool keeps idle keep-alive connections per pool member, connections idle longer than idleTimeout are closed
+type connPool struct {
+	lock        sync.Mutex
+	idle        map[string][]*forwardConn
+	h2          map[string]*h2Transport
+	maxIdle     int
+	idleTimeout time.Duration
+	reused      uint64
+}
+
+func newConnPool(maxIdle int, idleTimeout time.Duration) *connPool {
+	return &connPool{
+		idle:        make(map[string][]*forwardConn),
+		h2:          make(map[string]*h2Transport),
+		maxIdle:     maxIdle,
+		idleTimeout: idleTimeout,
+	}
+}
+
+func (p *connPool) enabled() bool {
+	return p.maxIdle > 0 && p.idleTimeout > 0
+}
+
+// get returns the most recently used live connection of the member, nil if there is none
+func (p *connPool) get(memberID string) *forwardConn {
+	var stale []*forwardConn
+	defer func() { closeConns(stale) }()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	conns := p.idle[memberID]
+	for len(conns) > 0 {
+		conn := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+
+		if time.Since(conn.idleSince) < p.idleTimeout && conn.alive() {
+			p.setIdle(memberID, conns)
+			p.reused++
+			return conn
+		}
+		stale = append(stale, conn)
+	}
+	p.setIdle(memberID, conns)
+	return nil
+}
+
+// put keeps connection for the next request, it's closed when pool of the member is full
+func (p *connPool) put(memberID string, conn *forwardConn) {
+	p.lock.Lock()
+	if !p.enabled() || len(p.idle[memberID]) >= p.maxIdle {
+		p.lock.Unlock()
+		_ = conn.Close()
+		return
+	}
+
+	conn.idleSince = time.Now()
+	p.idle[memberID] = append(p.idle[memberID], conn)
+	p.lock.Unlock()
+}
+
+// acquireH2 returns transport shared by requests to the member, it's kept only when reuse is enabled
+func (p *connPool) acquireH2(memberID string, newTransport func() *http2.Transport) *h2Transport {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	transport, ok := p.h2[memberID]
+	if ok {
+		p.reused++
+	} else {
+		transport = &h2Transport{Transport: newTransport()}
+		if p.enabled() {
+			p.h2[memberID] = transport
+		}
+	}
+	transport.active++
+	return transport
+}
+
+// releaseH2 marks request to the member done, connections of transports not kept in the pool are closed
+// after the last request
+func (p *connPool) releaseH2(memberID string, transport *h2Transport) {
+	p.lock.Lock()
+	transport.active--
+	transport.idleSince = time.Now()
+	closeIdle := transport.active == 0 && p.h2[memberID] != transport
+	p.lock.Unlock()
+
+	if closeIdle {
+		transport.CloseIdleConnections()
+	}
+}
+
+func (p *connPool) setIdle(memberID string, conns []*forwardConn) {
+	if len(conns) == 0 {
+		delete(p.idle, memberID)
+		return
+	}
+	p.idle[memberID] = conns
+}
+
+// expire closes connections which are idle for too long or closed by the target,
+// connections of disconnected clients are dropped this way too
+func (p *connPool) expire() {
+	var stale []*forwardConn
+
+	p.lock.Lock()
+	for memberID, conns := range p.idle {
+		live := conns[:0]
+		for _, conn := range conns {
+			if time.Since(conn.idleSince) < p.idleTimeout && conn.alive() {
+				live = append(live, conn)
+			} else {
+				stale = append(stale, conn)
+			}
+		}
+		p.setIdle(memberID, live)
+	}
+
+	var staleH2 []*h2Transport
+	for memberID, transport := range p.h2 {
+		if transport.active == 0 && time.Since(transport.idleSince) >= p.idleTimeout {
+			delete(p.h2, memberID)
+			staleH2 = append(staleH2, transport)
+		}
+	}
+	p.lock.Unlock()
+
+	closeConns(stale)
+	for _, transport := range staleH2 {
+		transport.CloseIdleConnections()
+	}
+}
+
+// watch expires idle connections every interval
+func (p *connPool) watch(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			p.expire()
+		}
+	}()
+}
+
+// stats returns number of idle connections and how many times a connection was reused
+func (p *connPool) stats() (idle int, reused uint64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, conns := range p.idle {
+		idle += len(conns)
+	}
+	return idle, p.reused
+}
+
+func closeConns(conns []*forwardConn) {
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestConn() *forwardConn {
+	conn, _ := net.Pipe()
+	return &forwardConn{Conn: conn, channel: conn, br: bufio.NewReader(conn)}
+}
+
+func TestConnPool_get(t *testing.T) {
+	pool := newConnPool(2, time.Minute)
+	first, second := newTestConn(), newTestConn()
+	pool.put("a", first)
+	pool.put("a", second)
+
+	if got := pool.get("b"); got != nil {
+		t.Error("get() returned connection of other member")
+	}
+	if got := pool.get("a"); got != second {
+		t.Error("get() didn't return the most recently used connection")
+	}
+	if got := pool.get("a"); got != first {
+		t.Error("get() didn't return the remaining connection")
+	}
+	if got := pool.get("a"); got != nil {
+		t.Error("get() returned connection from empty pool")
+	}
+
+	if _, reused := pool.stats(); reused != 2 {
+		t.Errorf("reused = %d, want 2", reused)
+	}
+}
+
+func TestConnPool_put(t *testing.T) {
+	pool := newConnPool(1, time.Minute)
+	pool.put("a", newTestConn())
+	pool.put("a", newTestConn())
+
+	if idle, _ := pool.stats(); idle != 1 {
+		t.Errorf("idle = %d, want 1", idle)
+	}
+
+	disabled := newConnPool(0, time.Minute)
+	disabled.put("a", newTestConn())
+	if idle, _ := disabled.stats(); idle != 0 {
+		t.Errorf("idle = %d, want 0 when disabled", idle)
+	}
+}
+
+func TestConnPool_expire(t *testing.T) {
+	pool := newConnPool(2, time.Minute)
+	expired, fresh := newTestConn(), newTestConn()
+	pool.put("a", expired)
+	pool.put("a", fresh)
+	expired.idleSince = time.Now().Add(-2 * time.Minute)
+
+	pool.expire()
+	if idle, _ := pool.stats(); idle != 1 {
+		t.Errorf("idle = %d, want 1", idle)
+	}
+	if got := pool.get("a"); got != fresh {
+		t.Error("expire() removed fresh connection")
+	}
+}
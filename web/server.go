@@ -2,44 +2,97 @@ package web
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
 	"net"
 	"net/http"
+	"r-ssh/certs"
 	"r-ssh/common"
 	"r-ssh/ssh"
-	"sync"
+	"strings"
 	"time"
 )
 
 var logger = logrus.WithField("component", "web")
 
+type ServerOptions struct {
+	HideInfo    bool
+	SslRedirect bool
+	// AdminToken enables admin endpoints
+	AdminToken string
+	// MaxBufferSize limits response data read ahead from http/1.1 targets, DefaultMaxBufferSize is used if not set.
+	// h2 targets are read ahead up to the flow control window of the h2 transport
+	MaxBufferSize int
+	// MaxIdleConns limits keep-alive connections kept per pool member, 0 disables reuse. h2 targets keep
+	// single multiplexed connection
+	MaxIdleConns int
+	// IdleConnTimeout closes keep-alive connections unused for that long, 0 disables reuse
+	IdleConnTimeout time.Duration
+	// UpstreamTimeout limits sending request to the forward and waiting for response headers, 0 disables it
+	UpstreamTimeout time.Duration
+	// CertManager issues certificates of custom domains, nil serves them with the configured certificate
+	CertManager *autocert.Manager
+	// Certificates are served by tls server name, they can be replaced while serving
+	Certificates *certs.Certificates
+}
+
 type Server struct {
-	host string
+	// hosts are base domains of tunnels, the first one is the application host
+	hosts []string
 
 	sshServer *ssh.Server
 
-	clientPool  sync.Pool
 	hideInfo    bool
 	sslRedirect bool
+	// customDomains routes hosts other than the application host by whole name
+	customDomains bool
+	adminToken    string
+	// maxBufferSize limits response data read ahead from the forward
+	maxBufferSize   int
+	upstreamTimeout time.Duration
+
+	// conns keeps keep-alive connections to forwards
+	conns       *connPool
+	hijacks     hijackGuard
+	certManager *autocert.Manager
+	h2Server    *http2.Server
+	// certificates of the application host, they're replaced on renewal
+	certificates *certs.Certificates
 
 	startTime time.Time
 }
 
-var domainSeparator = []byte(".")
+// stickyCookie keeps requests of the client on the same member of sticky pool
+const stickyCookie = "rssh_sticky"
 
-func (s *Server) acquireClient(conn net.Conn) *fasthttp.Client {
-	client := s.clientPool.Get().(*fasthttp.Client)
-	client.Dial = func(string) (net.Conn, error) {
-		return conn, nil
+// routeOf returns subdomain and the base domain it belongs to, with custom domains enabled other hosts
+// are routed by whole name with empty base domain
+func (s *Server) routeOf(host []byte) (string, string, bool) {
+	name := string(host)
+	if hostname, _, err := net.SplitHostPort(name); err == nil {
+		name = hostname
 	}
-	return client
-}
 
-func (s *Server) releaseClient(client *fasthttp.Client) {
-	client.Dial = nil
-	s.clientPool.Put(client)
+	lower := strings.ToLower(name)
+	underHost := false
+	for _, base := range s.hosts {
+		if strings.HasSuffix(lower, "."+base) {
+			subdomain := name[:len(name)-len(base)-1]
+			if subdomain != "" && !strings.Contains(subdomain, ".") {
+				return subdomain, base, true
+			}
+			underHost = true
+		}
+	}
+	if s.customDomains && !underHost && common.IsDomain(lower) {
+		return lower, "", true
+	}
+	return "", "", false
 }
 
 func (s *Server) statusHandler(ctx *fasthttp.RequestCtx) {
@@ -48,6 +101,10 @@ func (s *Server) statusHandler(ctx *fasthttp.RequestCtx) {
 }
 
 func (s *Server) requestHandler(ctx *fasthttp.RequestCtx) {
+	// challenges are fetched over plain http
+	if s.wellKnownHandler(ctx) {
+		return
+	}
 	if s.sslRedirect && !ctx.IsTLS() {
 		uri := ctx.Request.URI()
 		uri.SetScheme("https")
@@ -56,32 +113,47 @@ func (s *Server) requestHandler(ctx *fasthttp.RequestCtx) {
 		ctx.SetStatusCode(http.StatusPermanentRedirect)
 		return
 	}
-	urlParts := bytes.Split(ctx.Host(), domainSeparator)
-	if len(urlParts) != 3 {
+	subdomain, host, ok := s.routeOf(ctx.Host())
+	if !ok {
 		ctx.Error("subdomain required", http.StatusBadRequest)
 		return
 	}
 
-	subdomain := string(urlParts[0])
 	if subdomain == "status" {
+		if bytes.HasPrefix(ctx.Path(), adminPathPrefix) {
+			s.adminHandler(ctx)
+			return
+		}
 		s.statusHandler(ctx)
 		return
 	}
 
-	handler, err := s.sshServer.ForwardController().GetForwardHandler(subdomain)
+	forward, err := s.sshServer.ForwardController().AcquireForward(host, subdomain, string(ctx.Request.Header.Cookie(stickyCookie)))
 	if err != nil {
 		ctx.Error(err.Error(), http.StatusBadGateway)
 		return
 	}
+	// hijacked connections and streamed bodies release forward when they are closed
+	defer func() {
+		if !ctx.Hijacked() && !ctx.Response.IsBodyStream() {
+			forward.Release()
+		}
+	}()
 
-	conn, info, err := handler(ctx.RemoteAddr())
-	if err != nil {
-		logger.WithError(err).Warnln("create forward failed")
-		ctx.Error(err.Error(), http.StatusBadGateway)
+	if forward.Info.Passthrough {
+		ctx.Error("tls passthrough forward", http.StatusMisdirectedRequest)
+		return
+	}
+
+	info := forward.Info
+	if info.HTTP2 {
+		// fasthttp client speaks only http/1.1, h2 targets are proxied by net/http
+		s.proxyH2Target(ctx, forward)
 		return
 	}
 
 	req := &ctx.Request
+	req.Header.DelCookie(stickyCookie)
 
 	req.Header.Set("Via", common.ApplicationName)
 	req.Header.Set("X-Forwarded-For", ctx.RemoteIP().String())
@@ -106,43 +178,102 @@ func (s *Server) requestHandler(ctx *fasthttp.RequestCtx) {
 		req.URI().SetScheme("http")
 	}
 
-	client := s.acquireClient(conn)
-	err = client.Do(req, &ctx.Response)
-	s.releaseClient(client)
+	var source net.Addr
+	if isUpgrade(req) {
+		source, err = s.upgrade(ctx, forward)
+	} else {
+		source, err = s.roundTrip(ctx, forward)
+	}
 	if err != nil {
 		logger.WithError(err).Warnln("forward request failed")
-		ctx.Error(err.Error(), http.StatusBadGateway)
+		if isTimeout(err) {
+			ctx.Error(err.Error(), http.StatusGatewayTimeout)
+		} else {
+			ctx.Error(err.Error(), http.StatusBadGateway)
+		}
 		return
 	}
 
+	if info.Sticky {
+		cookie := fasthttp.AcquireCookie()
+		cookie.SetKey(stickyCookie)
+		cookie.SetValue(forward.MemberID)
+		cookie.SetPath("/")
+		cookie.SetHTTPOnly(true)
+		ctx.Response.Header.SetCookie(cookie)
+		fasthttp.ReleaseCookie(cookie)
+	}
+
 	if !s.hideInfo {
-		ctx.Response.Header.Set("X-Source", conn.RemoteAddr().String())
+		ctx.Response.Header.Set("X-Source", source.String())
 	}
 }
 
-func (s *Server) ListenTLS(endpoint, certFile, keyFile string) error {
+// ListenTLS terminates tls with certificate selected from Certificates option, except connections to passthrough forwards which are piped as is.
+// Clients negotiating h2 are served by net/http, status is offered only http/1.1
+func (s *Server) ListenTLS(endpoint string) error {
 	s.startTime = time.Now()
-	return fasthttp.ListenAndServeTLS(endpoint, certFile, keyFile, s.requestHandler)
+
+	listener, err := net.Listen("tcp4", endpoint)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate:           s.getCertificate,
+		PreferServerCipherSuites: true,
+		NextProtos:               []string{http2.NextProtoTLS, "http/1.1"},
+	}
+	if s.certManager != nil {
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+	}
+	http1Config := tlsConfig.Clone()
+	http1Config.NextProtos = []string{"http/1.1"}
+	tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if subdomain, _, ok := s.routeOf([]byte(hello.ServerName)); ok && subdomain == "status" {
+			return http1Config, nil
+		}
+		return nil, nil
+	}
+
+	return s.httpServer(s.requestHandler).Serve(newPassthroughListener(listener, tlsConfig, s.passthrough, s.serveHTTP2))
 }
 
 func (s *Server) Listen(endpoint string) error {
 	s.startTime = time.Now()
-	return fasthttp.ListenAndServe(endpoint, s.requestHandler)
+	return s.httpServer(s.requestHandler).ListenAndServe(endpoint)
 }
 
-func NewServer(sshServer *ssh.Server, host string, hideInfo, sslRedirect bool) *Server {
+// httpServer serves handler, connections closed instead of hijacked release what their hijack handler would
+func (s *Server) httpServer(handler fasthttp.RequestHandler) *fasthttp.Server {
+	return &fasthttp.Server{Handler: handler, ConnState: s.hijacks.connState}
+}
+
+func NewServer(sshServer *ssh.Server, options ServerOptions) *Server {
+	if options.MaxBufferSize <= 0 {
+		options.MaxBufferSize = DefaultMaxBufferSize
+	}
+	if options.Certificates == nil {
+		options.Certificates = certs.NewCertificates()
+	}
+
+	conns := newConnPool(options.MaxIdleConns, options.IdleConnTimeout)
+	if conns.enabled() {
+		conns.watch(options.IdleConnTimeout / 2)
+	}
+
 	return &Server{
-		hideInfo:    hideInfo,
-		sshServer:   sshServer,
-		host:        host,
-		sslRedirect: sslRedirect,
-		clientPool: sync.Pool{
-			New: func() interface{} {
-				return &fasthttp.Client{
-					MaxConnsPerHost: 1,
-					DialDualStack:   false,
-				}
-			},
-		},
+		hideInfo:        options.HideInfo,
+		sshServer:       sshServer,
+		hosts:           sshServer.Hosts(),
+		sslRedirect:     options.SslRedirect,
+		adminToken:      options.AdminToken,
+		maxBufferSize:   options.MaxBufferSize,
+		upstreamTimeout: options.UpstreamTimeout,
+		conns:           conns,
+		certManager:     options.CertManager,
+		certificates:    options.Certificates,
+		customDomains:   sshServer.DomainRegistry() != nil,
+		h2Server:        &http2.Server{},
 	}
 }
@@ -0,0 +1,35 @@
+package web
+
+import "testing"
+
+func TestServer_routeOf(t *testing.T) {
+	tests := []struct {
+		name          string
+		host          string
+		customDomains bool
+		want          string
+		wantBase      string
+		wantOK        bool
+	}{
+		{name: "Subdomain", host: "abc.example.com", want: "abc", wantBase: "example.com", wantOK: true},
+		{name: "Port", host: "abc.example.com:8443", want: "abc", wantBase: "example.com", wantOK: true},
+		{name: "Case", host: "Test-abc.Example.com", want: "Test-abc", wantBase: "example.com", wantOK: true},
+		{name: "Extra host", host: "abc.example.internal", want: "abc", wantBase: "example.internal", wantOK: true},
+		{name: "Nested host", host: "abc.dev.example.com", want: "abc", wantBase: "dev.example.com", wantOK: true},
+		{name: "Application host", host: "example.com", wantOK: false},
+		{name: "Nested subdomain", host: "a.b.example.com", wantOK: false},
+		{name: "Nested subdomain with custom domains", host: "a.b.example.internal", customDomains: true, wantOK: false},
+		{name: "Other host", host: "demo.customer.com", wantOK: false},
+		{name: "Custom domain", host: "Demo.Customer.com", customDomains: true, want: "demo.customer.com", wantOK: true},
+		{name: "Ip address", host: "127.0.0.1", customDomains: true, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{hosts: []string{"example.com", "example.internal", "dev.example.com"}, customDomains: tt.customDomains}
+			got, base, ok := s.routeOf([]byte(tt.host))
+			if ok != tt.wantOK || (ok && (got != tt.want || base != tt.wantBase)) {
+				t.Errorf("routeOf() = %q, %q, %v, want %q, %q, %v", got, base, ok, tt.want, tt.wantBase, tt.wantOK)
+			}
+		})
+	}
+}
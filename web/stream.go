@@ -0,0 +1,223 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"r-ssh/common"
+	"r-ssh/ssh"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultMaxBufferSize is the default amount of response data read ahead from the forward
+const DefaultMaxBufferSize = 64 * 1024
+
+// forwardBody is response body read from forward connection, closing it releases the forward.
+// Connection with fully read body goes back to the pool, otherwise it's closed
+type forwardBody struct {
+	io.Reader
+	conn    *forwardConn
+	forward *ssh.Forward
+	pool    *connPool
+	// complete reports the whole body was read, it's nil for bodies lasting until the connection is closed
+	complete func() bool
+
+	closeOnce sync.Once
+}
+
+// Close never fails, the target usually closes the channel first
+func (b *forwardBody) Close() error {
+	b.closeOnce.Do(func() {
+		if b.complete != nil && b.complete() {
+			b.pool.put(b.forward.MemberID, b.conn)
+		} else {
+			_ = b.conn.Close()
+		}
+		b.forward.Release()
+	})
+	return nil
+}
+
+// chunkedBody decodes chunked body and consumes trailer after the last chunk, so the next response can be read
+type chunkedBody struct {
+	reader io.Reader
+	br     *bufio.Reader
+	done   bool
+}
+
+func (c *chunkedBody) Read(b []byte) (int, error) {
+	n, err := c.reader.Read(b)
+	if err == io.EOF && !c.done {
+		if trailerErr := discardTrailer(c.br); trailerErr != nil {
+			return n, trailerErr
+		}
+		c.done = true
+	}
+	return n, err
+}
+
+// discardTrailer reads header lines until the empty line closing chunked body
+func discardTrailer(br *bufio.Reader) error {
+	for {
+		line, err := br.ReadSlice('\n')
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return nil
+		}
+	}
+}
+
+// isReplayable reports request can be sent again when reused connection turns out to be closed by the target
+func isReplayable(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTimeout reports upstream timeout, fasthttp reports timeouts while reading headers as its own error
+func isTimeout(err error) bool {
+	if err == fasthttp.ErrTimeout || err == common.ErrUpstreamTimeout {
+		return true
+	}
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// dial opens new channel to the forward for client at origin, responses are read with at most maxBufferSize bytes read ahead
+func (s *Server) dial(origin net.Addr, forward *ssh.Forward) (*forwardConn, error) {
+	channel, info, err := forward.Handler(origin)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := channel
+	if info.Https {
+		conn = tls.Client(channel, &tls.Config{ServerName: info.Host})
+	}
+	return &forwardConn{Conn: conn, channel: channel, br: bufio.NewReaderSize(conn, s.maxBufferSize)}, nil
+}
+
+// sendRequest writes request to forward connection, writing and waiting for response headers is limited by upstream timeout
+func (s *Server) sendRequest(req *fasthttp.Request, conn *forwardConn) error {
+	if s.upstreamTimeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(s.upstreamTimeout))
+	}
+
+	bw := bufio.NewWriter(conn)
+	err := req.Write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	return err
+}
+
+// isInterim reports informational response followed by the final one, e.g. 100 Continue or 103 Early Hints.
+// 101 Switching Protocols is the last response on the connection
+func isInterim(statusCode int) bool {
+	return statusCode >= http.StatusContinue && statusCode < http.StatusOK && statusCode != http.StatusSwitchingProtocols
+}
+
+// readResponseHeader skips interim responses, deadline is cleared once headers arrive
+func (s *Server) readResponseHeader(header *fasthttp.ResponseHeader, conn *forwardConn) error {
+	err := header.Read(conn.br)
+	for err == nil && isInterim(header.StatusCode()) {
+		err = header.Read(conn.br)
+	}
+	if err == nil && s.upstreamTimeout > 0 {
+		_ = conn.SetDeadline(time.Time{})
+	}
+	return err
+}
+
+func hasBody(req *fasthttp.Request, statusCode int) bool {
+	if req.Header.IsHead() {
+		return false
+	}
+	return statusCode >= http.StatusOK && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
+
+// exchange sends request over idle connection of the member or over new channel and reads response headers.
+// Replayable requests are retried on new channel when idle connection was closed by the target meanwhile
+func (s *Server) exchange(ctx *fasthttp.RequestCtx, forward *ssh.Forward) (*forwardConn, error) {
+	for {
+		conn := s.conns.get(forward.MemberID)
+		reused := conn != nil
+		if !reused {
+			var err error
+			if conn, err = s.dial(ctx.RemoteAddr(), forward); err != nil {
+				return nil, err
+			}
+		}
+
+		err := s.sendRequest(&ctx.Request, conn)
+		if err == nil {
+			err = s.readResponseHeader(&ctx.Response.Header, conn)
+		}
+		if err == nil {
+			return conn, nil
+		}
+
+		_ = conn.Close()
+		if !reused || isTimeout(err) || !isReplayable(string(ctx.Request.Header.Method())) {
+			return nil, err
+		}
+	}
+}
+
+// roundTrip reads only response headers, the body is streamed to the client as it arrives from the forward.
+// Slow clients slow down reading from the channel, so the ssh flow control pushes back to the target
+func (s *Server) roundTrip(ctx *fasthttp.RequestCtx, forward *ssh.Forward) (net.Addr, error) {
+	// fasthttp decides about the client connection before calling the handler,
+	// connection to the target is kept alive on its own
+	ctx.Request.Header.ResetConnectionClose()
+
+	conn, err := s.exchange(ctx, forward)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &ctx.Response.Header
+	reusable := !header.ConnectionClose()
+	header.ResetConnectionClose()
+
+	if !hasBody(&ctx.Request, header.StatusCode()) {
+		if reusable {
+			s.conns.put(forward.MemberID, conn)
+		} else {
+			_ = conn.Close()
+		}
+		return conn.RemoteAddr(), nil
+	}
+
+	body := &forwardBody{conn: conn, forward: forward, pool: s.conns}
+	contentLength := header.ContentLength()
+	switch {
+	case contentLength >= 0:
+		limited := &io.LimitedReader{R: conn.br, N: int64(contentLength)}
+		body.Reader = limited
+		body.complete = func() bool { return reusable && limited.N == 0 }
+	case contentLength == -1:
+		chunked := &chunkedBody{reader: httputil.NewChunkedReader(conn.br), br: conn.br}
+		body.Reader = chunked
+		body.complete = func() bool { return reusable && chunked.done }
+	default:
+		// body without length lasts until the target closes connection, it's sent to the client chunked
+		body.Reader = conn.br
+		contentLength = -1
+	}
+	ctx.Response.ImmediateHeaderFlush = true
+	ctx.Response.SetBodyStream(body, contentLength)
+	return conn.RemoteAddr(), nil
+}
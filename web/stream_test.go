@@ -0,0 +1,154 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"r-ssh/common"
+	"r-ssh/ssh"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// pipeForward is forward whose every connection is served by target on the other end of net.Pipe
+func pipeForward(info *common.ForwardInfo, target func(conn net.Conn)) *ssh.Forward {
+	return &ssh.Forward{
+		Info:     info,
+		MemberID: "a",
+		Handler: func(net.Addr) (net.Conn, *common.ForwardInfo, error) {
+			client, server := net.Pipe()
+			go target(server)
+			return client, info, nil
+		},
+	}
+}
+
+// serve runs handler by http server of s on in-memory listener, the listener is closed with the test
+func serve(t *testing.T, s *Server, handler fasthttp.RequestHandler) *fasthttputil.InmemoryListener {
+	listener := fasthttputil.NewInmemoryListener()
+	go func() { _ = s.httpServer(handler).Serve(listener) }()
+	t.Cleanup(func() { _ = listener.Close() })
+	return listener
+}
+
+func newTestClient(listener *fasthttputil.InmemoryListener) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(context.Context, string, string) (net.Conn, error) {
+				return listener.Dial()
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+}
+
+func TestServer_roundTripStreamsBody(t *testing.T) {
+	next := make(chan struct{})
+	forward := pipeForward(common.BuildForwardInfo("alice", common.DefaultForwardAddr, 80), func(conn net.Conn) {
+		defer conn.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\none\r\n")
+		// the rest is sent only after the client got the first piece
+		<-next
+		_, _ = io.WriteString(conn, "3\r\ntwo\r\n0\r\n\r\n")
+	})
+
+	s := &Server{maxBufferSize: DefaultMaxBufferSize, conns: newConnPool(0, 0)}
+	listener := serve(t, s, func(ctx *fasthttp.RequestCtx) {
+		if _, err := s.roundTrip(ctx, forward); err != nil {
+			ctx.Error(err.Error(), http.StatusBadGateway)
+		}
+	})
+
+	resp, err := newTestClient(listener).Get("http://app.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	piece := make([]byte, 3)
+	if _, err = io.ReadFull(resp.Body, piece); err != nil || string(piece) != "one" {
+		t.Fatalf("first piece = %q, %v, want one", piece, err)
+	}
+	close(next)
+	if rest, err := ioutil.ReadAll(resp.Body); err != nil || string(rest) != "two" {
+		t.Errorf("rest of body = %q, %v, want two", rest, err)
+	}
+}
+
+func TestHasBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		statusCode int
+		want       bool
+	}{
+		{name: "ok", method: http.MethodGet, statusCode: http.StatusOK, want: true},
+		{name: "head", method: http.MethodHead, statusCode: http.StatusOK, want: false},
+		{name: "no content", method: http.MethodGet, statusCode: http.StatusNoContent, want: false},
+		{name: "not modified", method: http.MethodGet, statusCode: http.StatusNotModified, want: false},
+		{name: "not found", method: http.MethodGet, statusCode: http.StatusNotFound, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &fasthttp.Request{}
+			req.Header.SetMethod(tt.method)
+			if got := hasBody(req, tt.statusCode); got != tt.want {
+				t.Errorf("hasBody() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsInterim(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		want       bool
+	}{
+		{statusCode: http.StatusContinue, want: true},
+		{statusCode: http.StatusEarlyHints, want: true},
+		{statusCode: http.StatusSwitchingProtocols, want: false},
+		{statusCode: http.StatusOK, want: false},
+	}
+	for _, tt := range tests {
+		if got := isInterim(tt.statusCode); got != tt.want {
+			t.Errorf("isInterim(%d) = %v, want %v", tt.statusCode, got, tt.want)
+		}
+	}
+}
+
+func TestServer_roundTripSkipsEarlyHints(t *testing.T) {
+	forward := pipeForward(common.BuildForwardInfo("alice", common.DefaultForwardAddr, 80), func(conn net.Conn) {
+		defer conn.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 103 Early Hints\r\nLink: </app.css>; rel=preload\r\n\r\n"+
+			"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	})
+
+	s := &Server{maxBufferSize: DefaultMaxBufferSize, conns: newConnPool(0, 0)}
+	listener := serve(t, s, func(ctx *fasthttp.RequestCtx) {
+		if _, err := s.roundTrip(ctx, forward); err != nil {
+			ctx.Error(err.Error(), http.StatusBadGateway)
+		}
+	})
+
+	resp, err := newTestClient(listener).Get("http://app.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("response = %d %q, want 200 \"ok\"", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,51 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"r-ssh/common"
+	"r-ssh/ssh"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// isUpgrade reports whether request asks to switch protocol, e.g. to WebSocket
+func isUpgrade(req *fasthttp.Request) bool {
+	return len(req.Header.Peek("Upgrade")) > 0 && req.Header.ConnectionUpgrade()
+}
+
+// upgrade relays upgrade request over new forward connection, after 101 response the client connection
+// is hijacked and spliced with the forward, any other response is relayed as usual
+func (s *Server) upgrade(ctx *fasthttp.RequestCtx, forward *ssh.Forward) (net.Addr, error) {
+	conn, err := s.dial(ctx.RemoteAddr(), forward)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.sendRequest(&ctx.Request, conn)
+	if err == nil {
+		err = ctx.Response.Read(conn.br)
+	}
+	for err == nil && isInterim(ctx.Response.StatusCode()) {
+		err = ctx.Response.Read(conn.br)
+	}
+	if err != nil || ctx.Response.StatusCode() != http.StatusSwitchingProtocols {
+		_ = conn.Close()
+		return conn.RemoteAddr(), err
+	}
+	// upgraded connection may stay quiet for long, upstream timeout applies only to the handshake
+	_ = conn.SetDeadline(time.Time{})
+
+	// fasthttp writes the response and stops serving the connection before calling the hijack handler,
+	// the handler isn't called when the client is gone meanwhile
+	ctx.Response.Header.ResetConnectionClose()
+	s.hijacks.hijack(ctx, func(clientConn net.Conn) {
+		defer forward.Release()
+		common.Pipe(clientConn, &peekedConn{Conn: conn.Conn, reader: conn.br})
+	}, func() {
+		_ = conn.Close()
+		forward.Release()
+	})
+	return conn.RemoteAddr(), nil
+}
@@ -0,0 +1,126 @@
+package web
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"r-ssh/common"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestIsUpgrade(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection string
+		upgrade    string
+		want       bool
+	}{
+		{name: "websocket", connection: "Upgrade", upgrade: "websocket", want: true},
+		{name: "token list", connection: "keep-alive, Upgrade", upgrade: "websocket", want: true},
+		{name: "no upgrade header", connection: "Upgrade", want: false},
+		{name: "keep-alive", connection: "keep-alive", upgrade: "websocket", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &fasthttp.Request{}
+			req.Header.Set("Connection", tt.connection)
+			if tt.upgrade != "" {
+				req.Header.Set("Upgrade", tt.upgrade)
+			}
+			if got := isUpgrade(req); got != tt.want {
+				t.Errorf("isUpgrade() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_upgrade(t *testing.T) {
+	forward := pipeForward(common.BuildForwardInfo("alice", common.DefaultForwardAddr, 80), func(conn net.Conn) {
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		// data right after the response must not get lost in the response reader
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\nhello")
+		_, _ = io.Copy(conn, br)
+	})
+
+	s := &Server{maxBufferSize: DefaultMaxBufferSize, conns: newConnPool(0, 0)}
+	listener := serve(t, s, func(ctx *fasthttp.RequestCtx) {
+		if _, err := s.upgrade(ctx, forward); err != nil {
+			ctx.Error(err.Error(), http.StatusBadGateway)
+		}
+	})
+
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	_, _ = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: app.example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+
+	data := make([]byte, 5)
+	if _, err = io.ReadFull(br, data); err != nil || string(data) != "hello" {
+		t.Fatalf("data from target = %q, %v, want hello", data, err)
+	}
+	_, _ = io.WriteString(conn, "ping")
+	data = data[:4]
+	if _, err = io.ReadFull(br, data); err != nil || string(data) != "ping" {
+		t.Errorf("echo = %q, %v, want ping", data, err)
+	}
+}
+
+func TestServer_upgradeAborted(t *testing.T) {
+	requested, respond, closed := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	forward := pipeForward(common.BuildForwardInfo("alice", common.DefaultForwardAddr, 80), func(conn net.Conn) {
+		defer close(closed)
+		br := bufio.NewReader(conn)
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		close(requested)
+		<-respond
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		// the target connection is closed once the upgrade is aborted
+		_, _ = io.Copy(ioutil.Discard, br)
+	})
+
+	s := &Server{maxBufferSize: DefaultMaxBufferSize, conns: newConnPool(0, 0)}
+	listener := serve(t, s, func(ctx *fasthttp.RequestCtx) {
+		if _, err := s.upgrade(ctx, forward); err != nil {
+			ctx.Error(err.Error(), http.StatusBadGateway)
+		}
+	})
+
+	conn, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: app.example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	// the client is gone before the response, so fasthttp never calls the hijack handler
+	<-requested
+	_ = conn.Close()
+	close(respond)
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("target connection of aborted upgrade wasn't closed")
+	}
+}